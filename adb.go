@@ -1,21 +1,31 @@
 package adbfs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"time"
 )
 
 func adbConnect(addr, svc string) (net.Conn, error) {
+	return adbConnectContext(context.Background(), addr, svc)
+}
+
+// adbConnectContext is like adbConnect, but ctx applies to connecting and
+// the service request.
+func adbConnectContext(ctx context.Context, addr, svc string) (net.Conn, error) {
 	if addr == "" {
 		addr = "localhost:5037"
 	}
-	conn, err := net.Dial("tcp", addr)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("connect %q: %w", addr, err)
 	}
-	if err := adbService(conn, svc); err != nil {
+	if err := adbServiceContext(ctx, conn, svc); err != nil {
 		conn.Close()
 		return nil, err
 	}
@@ -37,31 +47,50 @@ func adbConnectSingle(addr, svc string) ([]byte, error) {
 }
 
 func adbConnectDevice(addr, serial, svc string) (net.Conn, error) {
+	return adbConnectDeviceContext(context.Background(), addr, serial, svc)
+}
+
+// adbConnectDeviceContext is like adbConnectDevice, but ctx applies to
+// connecting and the service requests.
+func adbConnectDeviceContext(ctx context.Context, addr, serial, svc string) (net.Conn, error) {
 	var svc1 string
 	if serial != "" {
 		svc1 = "host:transport:" + serial
 	} else {
 		svc1 = "host:transport-any"
 	}
-	conn, err := adbConnect(addr, svc1)
+	conn, err := adbConnectContext(ctx, addr, svc1)
 	if err != nil {
 		return nil, err
 	}
-	if err := adbService(conn, svc); err != nil {
+	if err := adbServiceContext(ctx, conn, svc); err != nil {
 		conn.Close()
 		return nil, err
 	}
 	return conn, nil
 }
 
+// adbServiceContext is like adbService, but interrupts it if ctx is done.
+func adbServiceContext(ctx context.Context, conn net.Conn, svc string) error {
+	if ctx.Done() == nil {
+		return adbService(conn, svc)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	err := adbService(conn, svc)
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
 func adbService(conn net.Conn, svc string) error {
 	if err := adbSendMsg(conn, svc); err != nil {
 		return fmt.Errorf("service %q: send message: %w", svc, err)
 	}
-	if status, err := adbRecvStatus(conn); err != nil {
-		return fmt.Errorf("service %q: recv status: %w", svc, err)
-	} else if status != "OKAY" {
-		return fmt.Errorf("service %q: adb status %q", svc, status)
+	if err := adbRecvOkay(conn); err != nil {
+		return fmt.Errorf("service %q: %w", svc, err)
 	}
 	return nil
 }
@@ -79,6 +108,26 @@ func adbRecvStatus(conn net.Conn) (string, error) {
 	return string(b), nil
 }
 
+// adbRecvOkay reads a status, returning an error with the message if it
+// isn't OKAY.
+func adbRecvOkay(conn net.Conn) error {
+	status, err := adbRecvStatus(conn)
+	if err != nil {
+		return fmt.Errorf("recv status: %w", err)
+	}
+	switch status {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		msg, err := adbRecvMsg(conn)
+		if err != nil {
+			return fmt.Errorf("adb status %q (recv message: %w)", status, err)
+		}
+		return errors.New(string(msg))
+	}
+	return fmt.Errorf("adb status %q", status)
+}
+
 func adbRecvMsg(conn net.Conn) ([]byte, error) {
 	b := make([]byte, 4)
 	if _, err := io.ReadFull(conn, b); err != nil {
@@ -0,0 +1,49 @@
+package adbfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// https://cs.android.com/android/platform/superproject/main/+/main:packages/modules/adb/shell_protocol.h;drc=888a54dcbf954fdffacc8283a793290abcc589cd
+// https://cs.android.com/android/platform/superproject/main/+/main:packages/modules/adb/daemon/shell_service.cpp;drc=888a54dcbf954fdffacc8283a793290abcc589cd
+
+const (
+	shellFeature_shell_v2 string = "shell_v2"
+)
+
+type shellID byte
+
+const (
+	shellID_Stdin            shellID = 0
+	shellID_Stdout           shellID = 1
+	shellID_Stderr           shellID = 2
+	shellID_Exit             shellID = 3 // data is a single byte with the exit code
+	shellID_CloseStdin       shellID = 4
+	shellID_WindowSizeChange shellID = 5 // data is "rowsxcols,xpixelsxypixels"
+)
+
+// shellDataMax is the maximum amount of data to send in a single packet.
+const shellDataMax = 16 * 1024
+
+func shellSend(w io.Writer, id shellID, data []byte) error {
+	pkt := make([]byte, 1+4+len(data))
+	pkt[0] = byte(id)
+	binary.LittleEndian.PutUint32(pkt[1:5], uint32(len(data)))
+	copy(pkt[5:], data)
+	_, err := w.Write(pkt)
+	return err
+}
+
+func shellRecv(r io.Reader) (shellID, []byte, error) {
+	hdr := make([]byte, 1+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, nil, fmt.Errorf("read packet header: %w", err)
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(hdr[1:5]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("read packet data: %w", err)
+	}
+	return shellID(hdr[0]), data, nil
+}
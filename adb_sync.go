@@ -6,7 +6,6 @@ import (
 	"io"
 	"io/fs"
 	"net"
-	"strings"
 )
 
 const (
@@ -44,20 +43,32 @@ func (id syncID) String() string {
 	return string(id[:])
 }
 
-type syncFail string
-
-func (s syncFail) Error() string {
-	return "sync error: " + string(s)
-}
-
 const syncDataMax = 64 * 1024
 
-func syncRequest(conn net.Conn, id syncID, path string) error {
+func syncRequest(w io.Writer, id syncID, path string) error {
 	req := make([]byte, 4+4+len(path))
 	copy(req[0:4], id[:])
 	binary.LittleEndian.PutUint32(req[4:8], uint32(len(path)))
 	copy(req[8:], path)
-	_, err := conn.Write(req)
+	_, err := w.Write(req)
+	return err
+}
+
+func syncRequestData(w io.Writer, data []byte) error {
+	req := make([]byte, 4+4+len(data))
+	copy(req[0:4], syncID_DATA[:])
+	binary.LittleEndian.PutUint32(req[4:8], uint32(len(data)))
+	copy(req[8:], data)
+	_, err := w.Write(req)
+	return err
+}
+
+// syncRequestDone ends a SEND, with the mtime in place of the length.
+func syncRequestDone(w io.Writer, mtime uint32) error {
+	req := make([]byte, 4+4)
+	copy(req[0:4], syncID_DONE[:])
+	binary.LittleEndian.PutUint32(req[4:8], mtime)
+	_, err := w.Write(req)
 	return err
 }
 
@@ -107,18 +118,7 @@ func syncResponseCheck(conn net.Conn, id syncID) error {
 		if _, err := io.ReadFull(conn, tmp1); err != nil {
 			return fmt.Errorf("read error response: %w", err)
 		}
-		msg := string(tmp1)
-		switch { // libc error strings
-		case strings.HasSuffix(msg, ": Is a directory"):
-			return errIsDirectory
-		case strings.HasSuffix(msg, ": Not a directory"):
-			return errNotDirectory
-		case strings.HasSuffix(msg, ": Permission denied"):
-			return fs.ErrPermission
-		case strings.HasSuffix(msg, ": No such file or directory"):
-			return fs.ErrNotExist
-		}
-		return syncFail(msg)
+		return syncError(string(tmp1))
 	case syncID_OKAY:
 		var tmp sync_status
 		if err := binary.Read(conn, binary.LittleEndian, &tmp); err != nil {
@@ -179,6 +179,53 @@ type sync_dent_v2 struct {
 	// followed by `namelen` bytes of the name.
 }
 
+func syncStatV1(st *sync_stat_v1) *sync_stat_v2 {
+	return &sync_stat_v2{
+		Mode:  st.Mode,
+		Size:  uint64(st.Size),
+		Mtime: int64(st.Mtime),
+	}
+}
+
+func syncDentV1(st *sync_dent_v1) *sync_stat_v2 {
+	return &sync_stat_v2{
+		Mode:  st.Mode,
+		Size:  uint64(st.Size),
+		Mtime: int64(st.Mtime),
+	}
+}
+
+func syncDentV2(st *sync_dent_v2) *sync_stat_v2 {
+	return &sync_stat_v2{
+		Error: st.Error,
+		Dev:   st.Dev,
+		Ino:   st.Ino,
+		Mode:  st.Mode,
+		Nlink: st.Nlink,
+		Uid:   st.Uid,
+		Gid:   st.Gid,
+		Size:  st.Size,
+		Atime: st.Atime,
+		Mtime: st.Mtime,
+		Ctime: st.Ctime,
+	}
+}
+
+func syncFileStat(st *sync_stat_v2) *FileStat {
+	return &FileStat{
+		Dev:   st.Dev,
+		Ino:   st.Ino,
+		Mode:  st.Mode,
+		Nlink: st.Nlink,
+		Uid:   st.Uid,
+		Gid:   st.Gid,
+		Size:  st.Size,
+		Atime: st.Atime,
+		Mtime: st.Mtime,
+		Ctime: st.Ctime,
+	}
+}
+
 const (
 	syncFlag_None   uint32 = 0
 	syncFlag_Brotli uint32 = 1          // if syncFeature_sendrecv_v2_brotli
@@ -216,6 +263,49 @@ type sync_status struct {
 	// followed by `msglen` bytes of error message, if id == ID_FAIL.
 }
 
+// syncModeFrom is the inverse of syncMode.
+func syncModeFrom(m fs.FileMode) uint32 {
+	const (
+		S_IFBLK  = 0x6000
+		S_IFCHR  = 0x2000
+		S_IFDIR  = 0x4000
+		S_IFIFO  = 0x1000
+		S_IFLNK  = 0xa000
+		S_IFREG  = 0x8000
+		S_IFSOCK = 0xc000
+		S_ISGID  = 0x400
+		S_ISUID  = 0x800
+		S_ISVTX  = 0x200
+	)
+	mode := uint32(m.Perm())
+	switch m.Type() {
+	case fs.ModeDevice:
+		mode |= S_IFBLK
+	case fs.ModeDevice | fs.ModeCharDevice:
+		mode |= S_IFCHR
+	case fs.ModeDir:
+		mode |= S_IFDIR
+	case fs.ModeNamedPipe:
+		mode |= S_IFIFO
+	case fs.ModeSymlink:
+		mode |= S_IFLNK
+	case fs.ModeSocket:
+		mode |= S_IFSOCK
+	default:
+		mode |= S_IFREG
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= S_ISGID
+	}
+	if m&fs.ModeSetuid != 0 {
+		mode |= S_ISUID
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= S_ISVTX
+	}
+	return mode
+}
+
 func syncMode(mode uint32) fs.FileMode {
 	const (
 		S_BLKSIZE = 0x200
@@ -0,0 +1,394 @@
+package adbfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// WriteTar walks the tree rooted at root and writes it to w as a tar archive.
+// Entry names are relative to root (or the base name if root is a file), and
+// headers contain the mode, modification time, and owner from the file stat.
+// Symlinks are stored as symlink entries, and sockets are skipped.
+//
+// Entries are written in the order they are visited by Walk, and file
+// contents are streamed directly from the device. If an error occurs, the
+// archive is incomplete.
+func (c *FS) WriteTar(ctx context.Context, w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	if err := c.walkArchive(ctx, root, func(name string, fi fs.FileInfo, link string, r io.Reader) error {
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.ModTime = fi.ModTime()
+		if st, ok := fi.Sys().(*FileStat); ok {
+			hdr.Uid, hdr.Gid = int(st.Uid), int(st.Gid)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if r != nil {
+			return copyArchive(tw, r, hdr.Size)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// WriteZip is like WriteTar, but writes a zip archive. Files are compressed
+// with deflate, and the owner is stored in an Info-ZIP Unix extra field.
+func (c *FS) WriteZip(ctx context.Context, w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+	if err := c.walkArchive(ctx, root, func(name string, fi fs.FileInfo, link string, r io.Reader) error {
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		switch {
+		case fi.IsDir():
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+		case fi.Mode()&fs.ModeSymlink != 0:
+			hdr.Method = zip.Store
+			r = strings.NewReader(link)
+		case r != nil:
+			hdr.Method = zip.Deflate
+		}
+		hdr.Modified = fi.ModTime()
+		if st, ok := fi.Sys().(*FileStat); ok {
+			hdr.Extra = zipUnixExtra(hdr.Extra, st.Uid, st.Gid)
+		}
+		zf, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				_, err := io.Copy(zf, r)
+				return err
+			}
+			return copyArchive(zf, r, fi.Size())
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zipUnixExtra appends an Info-ZIP Unix extra field (0x7875) with the uid and
+// gid.
+func zipUnixExtra(b []byte, uid, gid uint32) []byte {
+	b = binary.LittleEndian.AppendUint16(b, 0x7875)
+	b = binary.LittleEndian.AppendUint16(b, 11)
+	b = append(b, 1, 4)
+	b = binary.LittleEndian.AppendUint32(b, uid)
+	b = append(b, 4)
+	b = binary.LittleEndian.AppendUint32(b, gid)
+	return b
+}
+
+// copyArchive copies exactly size bytes from r to w. If the file grew since it
+// was stat'd, the extra data is ignored.
+func copyArchive(w io.Writer, r io.Reader, size int64) error {
+	if n, err := io.CopyN(w, r, size); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("file shrank by %d bytes while reading", size-n)
+		}
+		return err
+	}
+	return nil
+}
+
+// walkArchive walks root for WriteTar and WriteZip, calling fn with the
+// archive name for each entry. For symlinks, link is the target. For regular
+// files, r reads the contents.
+func (c *FS) walkArchive(ctx context.Context, root string, fn func(name string, fi fs.FileInfo, link string, r io.Reader) error) error {
+	return c.Walk(ctx, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		var rel string
+		switch {
+		case name == root:
+			if d.IsDir() {
+				return nil
+			}
+			rel = path.Base(name)
+		case root == ".":
+			rel = name
+		default:
+			rel = name[len(root)+1:]
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var (
+			link string
+			r    io.Reader
+		)
+		switch fi.Mode().Type() {
+		case 0:
+			f, err := c.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = &ctxReader{ctx, f}
+		case fs.ModeSymlink:
+			if link, err = c.readlink(ctx, name); err != nil {
+				return err
+			}
+		case fs.ModeSocket:
+			return nil
+		}
+		if err := fn(rel, fi, link, r); err != nil {
+			return &fs.PathError{
+				Op:   "archive",
+				Path: name,
+				Err:  err,
+			}
+		}
+		return nil
+	}, nil)
+}
+
+// ctxReader stops reading once ctx is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ExtractTar extracts a tar archive into the directory root on the device,
+// creating it if necessary. Files are streamed to the device with the mode
+// and modification time from the archive, and parent directories are created
+// as needed. Entries with names outside root, or beneath a symlink created by
+// the archive, are rejected. Hard links and special files are not supported.
+//
+// If an error occurs, the archive may be partially extracted.
+func (c *FS) ExtractTar(ctx context.Context, r io.Reader, root string) error {
+	x, err := c.newExtractor(ctx, root)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var typ fs.FileMode
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeDir:
+			typ = fs.ModeDir
+		case tar.TypeSymlink:
+			typ = fs.ModeSymlink
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return &fs.PathError{
+				Op:   "extract",
+				Path: hdr.Name,
+				Err:  fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag),
+			}
+		}
+		if err := x.extract(hdr.Name, typ|fs.FileMode(hdr.Mode).Perm(), hdr.ModTime, hdr.Linkname, tr); err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// ExtractZip is like ExtractTar, but extracts a zip archive.
+func (c *FS) ExtractZip(ctx context.Context, r io.ReaderAt, size int64, root string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil && err != zip.ErrInsecurePath {
+		return err
+	}
+	x, err := c.newExtractor(ctx, root)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if err := x.extractZip(zf); err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+func (x *extractor) extractZip(zf *zip.File) error {
+	fi := zf.FileInfo()
+	if fi.Mode().Type()&^(fs.ModeDir|fs.ModeSymlink) != 0 {
+		return &fs.PathError{
+			Op:   "extract",
+			Path: zf.Name,
+			Err:  fmt.Errorf("unsupported zip entry type %s", fi.Mode().Type()),
+		}
+	}
+	if fi.IsDir() {
+		return x.extract(zf.Name, fi.Mode(), fi.ModTime(), "", nil)
+	}
+	r, err := zf.Open()
+	if err != nil {
+		return &fs.PathError{
+			Op:   "extract",
+			Path: zf.Name,
+			Err:  err,
+		}
+	}
+	defer r.Close()
+
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		buf, err := io.ReadAll(io.LimitReader(r, 4096))
+		if err != nil {
+			return &fs.PathError{
+				Op:   "extract",
+				Path: zf.Name,
+				Err:  err,
+			}
+		}
+		link = string(buf)
+	}
+	return x.extract(zf.Name, fi.Mode(), fi.ModTime(), link, r)
+}
+
+// extractor extracts archive entries for ExtractTar and ExtractZip.
+type extractor struct {
+	c     *FS
+	ctx   context.Context
+	root  string
+	links map[string]bool // symlinks created by the archive
+	dirs  []extractDir    // directories to set the mtime of
+}
+
+type extractDir struct {
+	name  string
+	mtime time.Time
+}
+
+func (c *FS) newExtractor(ctx context.Context, root string) (*extractor, error) {
+	if !fs.ValidPath(root) {
+		return nil, &fs.PathError{
+			Op:   "extract",
+			Path: root,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	if root != "." {
+		if err := c.MkdirAll(root, 0777); err != nil {
+			return nil, err
+		}
+	}
+	return &extractor{
+		c:     c,
+		ctx:   ctx,
+		root:  root,
+		links: map[string]bool{},
+	}, nil
+}
+
+// extract extracts a single entry. For regular files, r reads the contents.
+func (x *extractor) extract(name string, mode fs.FileMode, mtime time.Time, link string, r io.Reader) error {
+	if err := x.ctx.Err(); err != nil {
+		return err
+	}
+
+	rel, err := x.check(name, mode)
+	if err != nil {
+		return err
+	}
+	if rel == "." && mode.IsDir() {
+		return nil
+	}
+
+	target := rel
+	if x.root != "." {
+		target = x.root + "/" + rel
+	}
+	switch {
+	case mode.IsDir():
+		if err := x.c.MkdirAll(target, mode.Perm()); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, extractDir{target, mtime})
+		return nil
+	case mode&fs.ModeSymlink != 0:
+		return x.c.Symlink(link, target)
+	default:
+		return x.c.Send(target, &ctxReader{x.ctx, r}, mode.Perm(), mtime)
+	}
+}
+
+// check returns the cleaned path of an entry relative to root, or an error if
+// it is outside root or beneath a symlink created by an earlier entry. If the
+// entry is a symlink, it is recorded for later entries.
+func (x *extractor) check(name string, mode fs.FileMode) (string, error) {
+	rel := path.Clean(name)
+	if !fs.ValidPath(rel) {
+		return "", &fs.PathError{
+			Op:   "extract",
+			Path: name,
+			Err:  errors.New("path escapes the destination directory"),
+		}
+	}
+	for p := path.Dir(rel); p != "."; p = path.Dir(p) {
+		if x.links[p] {
+			return "", &fs.PathError{
+				Op:   "extract",
+				Path: name,
+				Err:  errors.New("path is beneath a symlink"),
+			}
+		}
+	}
+	if mode&fs.ModeSymlink != 0 {
+		x.links[rel] = true
+	}
+	return rel, nil
+}
+
+// finish sets directory modification times, which are updated when their
+// contents are extracted.
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		if d := x.dirs[i]; !d.mtime.IsZero() {
+			if err := x.c.Chtimes(d.name, time.Time{}, d.mtime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
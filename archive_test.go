@@ -0,0 +1,193 @@
+package adbfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractorCheck(t *testing.T) {
+	type entry struct {
+		name string
+		link string // symlink target, if a symlink
+		ok   bool
+	}
+	for _, tc := range []struct {
+		name    string
+		entries []entry
+	}{
+		{"Relative", []entry{{name: "a/b", ok: true}, {name: "./c", ok: true}}},
+		{"Parent", []entry{{name: "../x"}}},
+		{"Absolute", []entry{{name: "/abs"}}},
+		{"CleanedParent", []entry{{name: "a/../../x"}}},
+		{"CleanedInside", []entry{{name: "a/../x", ok: true}}},
+		{"BeneathSymlink", []entry{{name: "link", link: "/", ok: true}, {name: "link/x"}}},
+		{"BeneathNestedSymlink", []entry{{name: "a/link", link: "../..", ok: true}, {name: "a/link/b/x"}}},
+		{"SymlinkSibling", []entry{{name: "link", link: "/", ok: true}, {name: "linkx/y", ok: true}}},
+	} {
+		var tbuf, zbuf bytes.Buffer
+		tw, zw := tar.NewWriter(&tbuf), zip.NewWriter(&zbuf)
+		for _, e := range tc.entries {
+			hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg}
+			zh := &zip.FileHeader{Name: e.name}
+			zh.SetMode(0644)
+			if e.link != "" {
+				hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+				zh.SetMode(fs.ModeSymlink | 0777)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			w, err := zw.CreateHeader(zh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(e.link))
+		}
+		tw.Close()
+		zw.Close()
+
+		t.Run(tc.name+"/Tar", func(t *testing.T) {
+			x := &extractor{root: ".", links: map[string]bool{}}
+			tr := tar.NewReader(&tbuf)
+			for _, e := range tc.entries {
+				hdr, err := tr.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := x.check(hdr.Name, hdr.FileInfo().Mode()); (err == nil) != e.ok {
+					t.Errorf("%q: expected ok=%t, got error %v", e.name, e.ok, err)
+				}
+			}
+		})
+		t.Run(tc.name+"/Zip", func(t *testing.T) {
+			x := &extractor{root: ".", links: map[string]bool{}}
+			zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+			if err != nil && err != zip.ErrInsecurePath {
+				t.Fatal(err)
+			}
+			for i, e := range tc.entries {
+				zf := zr.File[i]
+				if _, err := x.check(zf.Name, zf.FileInfo().Mode()); (err == nil) != e.ok {
+					t.Errorf("%q: expected ok=%t, got error %v", e.name, e.ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	c, _, dir := newTestFS(t, "")
+	for name, data := range map[string]string{"a.txt": "hello", "sub/b.txt": "world"} {
+		if err := os.MkdirAll(filepath.Dir("/"+dir+"/"+name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("/"+dir+"/"+name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", "/"+dir+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", "/"+dir+"/sock") // should be skipped
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	exp := []string{
+		"a.txt:-rw-r--r--:hello",
+		"link:Lrwxrwxrwx:a.txt",
+		"sub/:drwxr-xr-x:",
+		"sub/b.txt:-rw-r--r--:world",
+	}
+
+	t.Run("Tar", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := c.WriteTar(context.Background(), &buf, dir); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		var ents []string
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("read %q: %v", hdr.Name, err)
+			}
+			if hdr.Typeflag == tar.TypeSymlink {
+				data = []byte(hdr.Linkname)
+			}
+			if hdr.Uid != fakeUID || hdr.Gid != fakeGID {
+				t.Errorf("%q: expected owner %d:%d, got %d:%d", hdr.Name, fakeUID, fakeGID, hdr.Uid, hdr.Gid)
+			}
+			ents = append(ents, hdr.Name+":"+hdr.FileInfo().Mode().String()+":"+string(data))
+		}
+		slices.Sort(ents)
+		if !slices.Equal(ents, exp) {
+			t.Errorf("expected %q, got %q", exp, ents)
+		}
+	})
+	t.Run("Zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := c.WriteZip(context.Background(), &buf, dir); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var ents []string
+		for _, zf := range zr.File {
+			r, err := zf.Open()
+			if err != nil {
+				t.Fatalf("read %q: %v", zf.Name, err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read %q: %v", zf.Name, err)
+			}
+			var uid, gid uint32
+			for b := zf.Extra; len(b) >= 4; {
+				id, n := binary.LittleEndian.Uint16(b), int(binary.LittleEndian.Uint16(b[2:]))
+				if id == 0x7875 && n == 11 {
+					uid, gid = binary.LittleEndian.Uint32(b[6:]), binary.LittleEndian.Uint32(b[11:])
+				}
+				b = b[min(4+n, len(b)):]
+			}
+			if uid != fakeUID || gid != fakeGID {
+				t.Errorf("%q: expected owner %d:%d, got %d:%d", zf.Name, fakeUID, fakeGID, uid, gid)
+			}
+			ents = append(ents, zf.Name+":"+zf.Mode().String()+":"+string(data))
+		}
+		slices.Sort(ents)
+		if !slices.Equal(ents, exp) {
+			t.Errorf("expected %q, got %q", exp, ents)
+		}
+	})
+}
+
+func TestCopyArchive(t *testing.T) {
+	var buf bytes.Buffer
+	if err := copyArchive(&buf, strings.NewReader("hello"), 3); err != nil || buf.String() != "hel" {
+		t.Errorf("grown: expected %q, got %q %v", "hel", buf.String(), err)
+	}
+	if err := copyArchive(io.Discard, strings.NewReader("hello"), 8); err == nil || err.Error() != "file shrank by 3 bytes while reading" {
+		t.Errorf("shrunk: expected error, got %v", err)
+	}
+}
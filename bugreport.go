@@ -0,0 +1,91 @@
+package adbfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Bugreport generates a zipped bugreport using bugreportz, then copies it to w
+// and deletes it from the device. If progress is not nil, it is called with
+// the progress reported by bugreportz (total may be zero if unknown). The file
+// name of the bugreport is returned. This requires Android 7.0 or later.
+func (c *FS) Bugreport(ctx context.Context, w io.Writer, progress func(done, total int)) (string, error) {
+	conn, err := adbConnectDeviceContext(ctx, c.addr, c.serial, "exec:bugreportz -p 2>&1")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	var (
+		name string // device path
+		last string
+	)
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		last = line
+		kind, arg, _ := strings.Cut(line, ":")
+		switch kind {
+		case "BEGIN":
+			if progress != nil {
+				progress(0, 0)
+			}
+		case "PROGRESS":
+			if progress != nil {
+				d, t, _ := strings.Cut(arg, "/")
+				done, _ := strconv.Atoi(d)
+				total, _ := strconv.Atoi(t)
+				progress(done, total)
+			}
+		case "OK":
+			name = arg
+		case "FAIL":
+			return "", fmt.Errorf("bugreport: %s", arg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("bugreport: %w", err)
+	}
+	if !stop() {
+		return "", ctx.Err()
+	}
+	if name == "" {
+		if last == "" {
+			last = "no output"
+		}
+		return "", fmt.Errorf("bugreport: %s", last)
+	}
+	if !path.IsAbs(name) {
+		return "", fmt.Errorf("bugreport: invalid path %q", name)
+	}
+	defer c.shell(context.Background(), "rm -f -- "+shellQuote(name), nil)
+
+	f, err := c.Open(strings.TrimPrefix(path.Clean(name), "/"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, &ctxReader{ctx, f}); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("bugreport: copy %s: %w", name, err)
+	}
+	return path.Base(name), nil
+}
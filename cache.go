@@ -0,0 +1,185 @@
+package adbfs
+
+import (
+	"container/list"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions contains options for NewCache.
+type CacheOptions struct {
+	// TTL is how long results are cached for. If zero, 10 seconds is used.
+	TTL time.Duration
+
+	// Size is the maximum number of cached results, with the least recently
+	// used ones being evicted first. If zero, 4096 is used.
+	Size int
+}
+
+// Cache wraps a FS, caching the results of Stat and ReadDir. Entries returned
+// by ReadDir are also used for Stat.
+//
+// Cached results are invalidated when the TTL expires, when Invalidate is
+// called, or when the path is modified using the FS.
+type Cache struct {
+	fs   *FS
+	ttl  time.Duration
+	size int
+
+	mu  sync.Mutex
+	lru *list.List // of *cacheEntry, most recently used first
+	ent map[cacheKey]*list.Element
+}
+
+var (
+	_ fs.FS         = (*Cache)(nil)
+	_ fs.StatFS     = (*Cache)(nil)
+	_ fs.ReadDirFS  = (*Cache)(nil)
+	_ fs.ReadFileFS = (*Cache)(nil)
+)
+
+type cacheKey struct {
+	dir  bool
+	name string
+}
+
+type cacheEntry struct {
+	key cacheKey
+	exp time.Time
+	fi  fs.FileInfo
+	de  []fs.DirEntry
+}
+
+// NewCache creates a new cache for fsys. The cache is registered with fsys to
+// be notified of modifications until it is closed, so it must be closed when no
+// longer in use, otherwise neither it nor fsys (including its pooled
+// connections) can be garbage collected.
+func NewCache(fsys *FS, opts *CacheOptions) *Cache {
+	c := &Cache{
+		fs:   fsys,
+		ttl:  10 * time.Second,
+		size: 4096,
+		lru:  list.New(),
+		ent:  make(map[cacheKey]*list.Element),
+	}
+	if opts != nil {
+		if opts.TTL > 0 {
+			c.ttl = opts.TTL
+		}
+		if opts.Size > 0 {
+			c.size = opts.Size
+		}
+	}
+	fsys.addCache(c)
+	return c
+}
+
+// Close clears the cache and stops it from being notified of modifications
+// made using the FS. It does not close the underlying FS.
+func (c *Cache) Close() error {
+	c.fs.delCache(c)
+	c.InvalidateAll()
+	return nil
+}
+
+// Invalidate removes cached results for name, its parent directory, and
+// anything under it. It scans every cached result.
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name = path.Clean(name)
+	parent := path.Dir(name)
+	for key, el := range c.ent {
+		if key.name == name || (key.dir && key.name == parent) || name == "." || strings.HasPrefix(key.name, name+"/") {
+			c.lru.Remove(el)
+			delete(c.ent, key)
+		}
+	}
+}
+
+// InvalidateAll removes all cached results.
+func (c *Cache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.ent)
+}
+
+func (c *Cache) Open(name string) (fs.File, error) {
+	return c.fs.Open(name)
+}
+
+func (c *Cache) ReadFile(name string) ([]byte, error) {
+	return c.fs.ReadFile(name)
+}
+
+func (c *Cache) Stat(name string) (fs.FileInfo, error) {
+	if e := c.get(cacheKey{false, name}); e != nil {
+		return e.fi, nil
+	}
+	fi, err := c.fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	c.put(&cacheEntry{key: cacheKey{false, name}, fi: fi})
+	return fi, nil
+}
+
+func (c *Cache) ReadDir(name string) ([]fs.DirEntry, error) {
+	if e := c.get(cacheKey{true, name}); e != nil {
+		return slices.Clone(e.de), nil
+	}
+	de, err := c.fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range de {
+		if fi, err := d.Info(); err == nil {
+			c.put(&cacheEntry{key: cacheKey{false, path.Join(name, d.Name())}, fi: fi})
+		}
+	}
+	c.put(&cacheEntry{key: cacheKey{true, name}, de: slices.Clone(de)})
+	return de, nil
+}
+
+func (c *Cache) get(key cacheKey) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.ent[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.exp) {
+		c.lru.Remove(el)
+		delete(c.ent, key)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *Cache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.exp = time.Now().Add(c.ttl)
+	if el, ok := c.ent[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.ent[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.ent, el.Value.(*cacheEntry).key)
+	}
+}
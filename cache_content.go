@@ -0,0 +1,259 @@
+package adbfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ContentCacheOptions contains options for NewContentCache.
+type ContentCacheOptions struct {
+	// Dir is the directory to store cached files in. If empty, a directory
+	// inside os.UserCacheDir is used. It may be shared between multiple caches
+	// and devices.
+	Dir string
+
+	// Size is the maximum total size of the cached files in bytes, with the
+	// least recently used ones being evicted first. Files larger than this are
+	// never cached. If zero, 256 MiB is used.
+	Size int64
+}
+
+// ContentCache wraps a FS, caching the contents of regular files read with
+// ReadFile on the local disk.
+//
+// Before a cached file is used, it is validated using a single stat, comparing
+// the size, the modification time, and the inode number (if the device supports
+// stat_v2). Since the modification time only has a resolution of one second,
+// files which are modified multiple times per second without changing their
+// size may not be detected as modified.
+type ContentCache struct {
+	fs   *FS
+	dir  string
+	size int64
+	mu   sync.Mutex // for evictions
+}
+
+var (
+	_ fs.FS         = (*ContentCache)(nil)
+	_ fs.ReadFileFS = (*ContentCache)(nil)
+)
+
+// contentCacheMagic is the header for cached files, and should be changed if
+// the format changes.
+var contentCacheMagic = [8]byte{'a', 'd', 'b', 'f', 's', 'c', 0, 1}
+
+type contentCacheHeader struct {
+	Magic [8]byte
+	Dev   uint64
+	Ino   uint64
+	Size  uint64
+	Mtime int64
+}
+
+func newContentCacheHeader(st *FileStat) contentCacheHeader {
+	return contentCacheHeader{
+		Magic: contentCacheMagic,
+		Dev:   st.Dev,
+		Ino:   st.Ino,
+		Size:  st.Size,
+		Mtime: st.Mtime,
+	}
+}
+
+// NewContentCache creates a new content cache for fsys.
+func NewContentCache(fsys *FS, opts *ContentCacheOptions) (*ContentCache, error) {
+	c := &ContentCache{
+		fs:   fsys,
+		size: 256 * 1024 * 1024,
+	}
+	if opts != nil {
+		c.dir = opts.Dir
+		if opts.Size > 0 {
+			c.size = opts.Size
+		}
+	}
+	if c.dir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("get cache dir: %w", err)
+		}
+		c.dir = filepath.Join(dir, "go-adbfs")
+	}
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return c, nil
+}
+
+func (c *ContentCache) Open(name string) (fs.File, error) {
+	return c.fs.Open(name)
+}
+
+// ReadFile reads the named file, using the cached contents if the file has not
+// changed.
+func (c *ContentCache) ReadFile(name string) ([]byte, error) {
+	fi, err := c.fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	st := fi.Sys().(*FileStat)
+
+	// things like procfs and sysfs files report a zero size
+	if !fi.Mode().IsRegular() || st.Size == 0 || st.Size > uint64(c.size) {
+		return c.fs.ReadFile(name)
+	}
+
+	hdr := newContentCacheHeader(st)
+	fn := c.path(name)
+
+	if buf, err := os.ReadFile(fn); err == nil {
+		if buf, ok := hdr.check(buf); ok {
+			now := time.Now()
+			_ = os.Chtimes(fn, now, now)
+			return buf, nil
+		}
+	}
+
+	buf, err := c.fs.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure it didn't change while we were reading it
+	if uint64(len(buf)) != st.Size {
+		return buf, nil
+	}
+	if fi, err := c.fs.Stat(name); err != nil {
+		return buf, nil
+	} else if newContentCacheHeader(fi.Sys().(*FileStat)) != hdr {
+		return buf, nil
+	}
+
+	_ = c.put(fn, &hdr, buf)
+	return buf, nil
+}
+
+// Clear removes all files from the cache directory, including ones for other
+// devices.
+func (c *ContentCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, ent := range ents {
+		if filepath.Ext(ent.Name()) == ".cache" {
+			if err := os.Remove(filepath.Join(c.dir, ent.Name())); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ContentCache) path(name string) string {
+	h := sha256.New()
+	h.Write([]byte(c.fs.serial))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return filepath.Join(c.dir, hex.EncodeToString(h.Sum(nil))+".cache")
+}
+
+func (c *ContentCache) put(fn string, hdr *contentCacheHeader, buf []byte) error {
+	tmp, err := os.CreateTemp(c.dir, ".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := binary.Write(tmp, binary.LittleEndian, hdr); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), fn); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used files until the cache is within the
+// size limit. It must be called with mu held.
+func (c *ContentCache) evict() error {
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var (
+		total int64
+		files []fs.FileInfo
+	)
+	for _, ent := range ents {
+		if filepath.Ext(ent.Name()) != ".cache" {
+			continue
+		}
+		fi, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		total += fi.Size()
+		files = append(files, fi)
+	}
+	if total <= c.size {
+		return nil
+	}
+
+	slices.SortFunc(files, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, fi := range files {
+		if total <= c.size {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, fi.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= fi.Size()
+	}
+	return nil
+}
+
+// check checks if buf is a cached file matching hdr, returning the contents.
+func (hdr *contentCacheHeader) check(buf []byte) ([]byte, bool) {
+	var tmp contentCacheHeader
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &tmp); err != nil {
+		return nil, false
+	}
+	if tmp != *hdr {
+		return nil, false
+	}
+	buf = buf[binary.Size(tmp):]
+	if uint64(len(buf)) != hdr.Size {
+		return nil, false
+	}
+	return buf, true
+}
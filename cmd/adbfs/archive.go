@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+)
+
+func init() {
+	commands["archive"] = &command{
+		Usage: "[-zip] path",
+		Short: "write a directory to stdout as a tar or zip archive",
+		Run:   archive,
+	}
+	commands["extract"] = &command{
+		Usage: "[-zip] path",
+		Short: "extract a tar or zip archive from stdin into a directory",
+		Run:   extract,
+	}
+}
+
+func archive(args []string) error {
+	flags := newFlagSet("archive")
+	zip := flags.Bool("zip", false, "write a zip archive instead of a tar archive")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	w := bufio.NewWriterSize(os.Stdout, 1<<20)
+	if *zip {
+		err = fsys.WriteZip(context.Background(), w, resolve(flags.Arg(0)))
+	} else {
+		err = fsys.WriteTar(context.Background(), w, resolve(flags.Arg(0)))
+	}
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
+
+func extract(args []string) error {
+	flags := newFlagSet("extract")
+	zip := flags.Bool("zip", false, "read a zip archive instead of a tar archive (stdin must be a file)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	if *zip {
+		fi, err := os.Stdin.Stat()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("zip archives must be read from a file")
+		}
+		return fsys.ExtractZip(context.Background(), os.Stdin, fi.Size(), resolve(flags.Arg(0)))
+	}
+	return fsys.ExtractTar(context.Background(), bufio.NewReaderSize(os.Stdin, 1<<20), resolve(flags.Arg(0)))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+func init() {
+	commands["bugreport"] = &command{
+		Usage: "[path]",
+		Short: "save a zipped bugreport",
+		Run:   bugreport,
+	}
+}
+
+func bugreport(args []string) error {
+	flags := newFlagSet("bugreport")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s bugreport %s\n\nIf path is a directory or not specified, the bugreport is saved there using the name from the device.\n\n", os.Args[0], commands["bugreport"].Usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dst := flags.Arg(0)
+	if dst == "" {
+		dst = "."
+	}
+	var dir bool
+	if fi, err := os.Stat(dst); err == nil {
+		dir = fi.IsDir()
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	// the name isn't known until the bugreport is done
+	tmpDir := dst
+	if !dir {
+		tmpDir = filepath.Dir(dst)
+	}
+	f, err := os.CreateTemp(tmpDir, ".bugreport-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	name, err := fsys.Bugreport(ctx, f, func(done, total int) {
+		if *jsonFlag {
+			return
+		}
+		if total > 0 {
+			fmt.Fprintf(os.Stderr, "\r[%3d%%] generating bugreport", done*100/total)
+		} else {
+			fmt.Fprintf(os.Stderr, "\r[  0%%] generating bugreport")
+		}
+	})
+	if !*jsonFlag {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if dir {
+		dst = filepath.Join(dst, name)
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return err
+	}
+	if *jsonFlag {
+		return printJSON(struct {
+			Local string `json:"local"`
+		}{dst})
+	}
+	fmt.Fprintf(os.Stderr, "bugreport saved to %s\n", dst)
+	return nil
+}
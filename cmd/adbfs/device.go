@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["root"] = &command{
+		Usage: "",
+		Short: "restart adbd as root",
+		Run: deviceCommand("root", func(fsys *adbfs.FS, ctx context.Context) (string, error) {
+			return "", fsys.Root(ctx)
+		}),
+	}
+	commands["unroot"] = &command{
+		Usage: "",
+		Short: "restart adbd as the shell user",
+		Run: deviceCommand("unroot", func(fsys *adbfs.FS, ctx context.Context) (string, error) {
+			return "", fsys.Unroot(ctx)
+		}),
+	}
+	commands["remount"] = &command{
+		Usage: "",
+		Short: "remount system partitions read-write",
+		Run:   deviceCommand("remount", (*adbfs.FS).Remount),
+	}
+	commands["disable-verity"] = &command{
+		Usage: "",
+		Short: "disable dm-verity on system partitions",
+		Run:   deviceCommand("disable-verity", (*adbfs.FS).DisableVerity),
+	}
+}
+
+// deviceCommand returns a command which calls fn and prints the output.
+func deviceCommand(name string, fn func(*adbfs.FS, context.Context) (string, error)) func(args []string) error {
+	return func(args []string) error {
+		flags := newFlagSet(name)
+		flags.Parse(args)
+
+		if flags.NArg() != 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		fsys, err := connect("")
+		if err != nil {
+			return err
+		}
+		defer fsys.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		// restarting adbd waits for the device to come back
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		out, err := fn(fsys, ctx)
+		if out = strings.TrimSpace(out); out != "" {
+			fmt.Println(out)
+		}
+		return err
+	}
+}
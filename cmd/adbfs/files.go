@@ -0,0 +1,684 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["ls"] = &command{
+		Usage: "[-l] [-a] [-d] [path...]",
+		Short: "list directory contents",
+		Run:   ls,
+	}
+	commands["stat"] = &command{
+		Usage: "path...",
+		Short: "show file information",
+		Run:   stat,
+	}
+	commands["cat"] = &command{
+		Usage: "path...",
+		Short: "write files to stdout",
+		Run:   cat,
+	}
+	commands["tree"] = &command{
+		Usage: "[-a] [-L level] [path...]",
+		Short: "show a directory tree",
+		Run:   tree,
+	}
+	commands["find"] = &command{
+		Usage: "[-name pattern] [-type f|d|l] [-newer path] [-size [+|-]n[c|k|M|G]] [-maxdepth n] [path...]",
+		Short: "search for files",
+		Run:   find,
+	}
+	commands["du"] = &command{
+		Usage: "[-s] [-b|-h] [path...]",
+		Short: "show disk usage",
+		Run:   du,
+	}
+}
+
+func ls(args []string) error {
+	flags := newFlagSet("ls")
+	long := flags.Bool("l", false, "use a long listing format")
+	all := flags.Bool("a", false, "include entries starting with a dot")
+	dir := flags.Bool("d", false, "list directories themselves, not their contents")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = resolve(p)
+	}
+	fis, errs := fsys.StatMany(names)
+
+	// files first, then directories, like ls
+	var (
+		files []lsEntry
+		dirs  []int
+	)
+	for i, fi := range fis {
+		switch {
+		case errs[i] != nil:
+			warn(errs[i])
+		case fi.IsDir() && !*dir:
+			dirs = append(dirs, i)
+		default:
+			files = append(files, lsEntry{paths[i], names[i], fi})
+		}
+	}
+	if err := lsPrint(files, *long); err != nil {
+		return err
+	}
+
+	dirNames := make([]string, len(dirs))
+	for j, i := range dirs {
+		dirNames[j] = names[i]
+	}
+	de, errs := fsys.ReadDirMany(dirNames)
+	for j, i := range dirs {
+		if errs[j] != nil {
+			warn(errs[j])
+			continue
+		}
+		if len(paths) > 1 && !*jsonFlag {
+			if j != 0 || len(files) != 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", paths[i])
+		}
+		var ents []lsEntry
+		for _, d := range de[j] {
+			if !*all && strings.HasPrefix(d.Name(), ".") {
+				continue
+			}
+			fi, err := d.Info()
+			if err != nil {
+				warn(err)
+				continue
+			}
+			ents = append(ents, lsEntry{d.Name(), path.Join(names[i], d.Name()), fi})
+		}
+		slices.SortFunc(ents, func(a, b lsEntry) int {
+			return strings.Compare(a.display, b.display)
+		})
+		if err := lsPrint(ents, *long); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type lsEntry struct {
+	display string
+	name    string
+	fi      fs.FileInfo
+}
+
+func lsPrint(ents []lsEntry, long bool) error {
+	if *jsonFlag {
+		for _, e := range ents {
+			if err := printJSON(newEntry(e.name, e.fi)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !long {
+		for _, e := range ents {
+			fmt.Println(e.display)
+		}
+		return nil
+	}
+	rows := make([][]string, len(ents))
+	for i, e := range ents {
+		st := e.fi.Sys().(*adbfs.FileStat)
+		rows[i] = []string{
+			modeString(e.fi),
+			strconv.FormatUint(uint64(max(st.Nlink, 1)), 10),
+			strconv.FormatUint(uint64(st.Uid), 10),
+			strconv.FormatUint(uint64(st.Gid), 10),
+			strconv.FormatInt(e.fi.Size(), 10),
+			e.fi.ModTime().Format("2006-01-02 15:04"),
+			e.display,
+		}
+	}
+	printColumns(rows, []bool{false, true, false, false, true, false, false})
+	return nil
+}
+
+// printColumns prints rows with aligned columns. The last column is never
+// padded.
+func printColumns(rows [][]string, right []bool) {
+	var width []int
+	for _, row := range rows {
+		for i, col := range row {
+			if i >= len(width) {
+				width = append(width, 0)
+			}
+			width[i] = max(width[i], len(col))
+		}
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		b.Reset()
+		for i, col := range row {
+			if i != 0 {
+				b.WriteByte(' ')
+			}
+			pad := strings.Repeat(" ", width[i]-len(col))
+			switch {
+			case i == len(row)-1:
+				b.WriteString(col)
+			case right[i]:
+				b.WriteString(pad + col)
+			default:
+				b.WriteString(col + pad)
+			}
+		}
+		fmt.Println(b.String())
+	}
+}
+
+func stat(args []string) error {
+	flags := newFlagSet("stat")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	names := make([]string, flags.NArg())
+	for i, p := range flags.Args() {
+		names[i] = resolve(p)
+	}
+	fis, errs := fsys.StatMany(names)
+	for i, fi := range fis {
+		if errs[i] != nil {
+			warn(errs[i])
+			continue
+		}
+		if *jsonFlag {
+			if err := printJSON(newEntry(names[i], fi)); err != nil {
+				return err
+			}
+			continue
+		}
+		st := fi.Sys().(*adbfs.FileStat)
+		fmt.Printf("  File: %s\n", devicePath(names[i]))
+		fmt.Printf("  Size: %-15d Type: %s\n", st.Size, typeString(fi.Mode()))
+		fmt.Printf("  Mode: (%04o/%s)  Uid: %d  Gid: %d\n", st.Mode&07777, modeString(fi), st.Uid, st.Gid)
+		if st.Ino != 0 {
+			fmt.Printf("Device: %xh/%dd  Inode: %d  Links: %d\n", st.Dev, st.Dev, st.Ino, st.Nlink)
+		}
+		if st.Atime != 0 {
+			fmt.Printf("Access: %s\n", time.Unix(st.Atime, 0).Format(time.RFC3339))
+		}
+		fmt.Printf("Modify: %s\n", time.Unix(st.Mtime, 0).Format(time.RFC3339))
+		if st.Ctime != 0 {
+			fmt.Printf("Change: %s\n", time.Unix(st.Ctime, 0).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func typeString(m fs.FileMode) string {
+	switch m.Type() {
+	case 0:
+		return "regular file"
+	case fs.ModeDir:
+		return "directory"
+	case fs.ModeSymlink:
+		return "symbolic link"
+	case fs.ModeNamedPipe:
+		return "fifo"
+	case fs.ModeSocket:
+		return "socket"
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return "character special file"
+	case fs.ModeDevice:
+		return "block special file"
+	}
+	return "unknown"
+}
+
+func cat(args []string) error {
+	flags := newFlagSet("cat")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	for _, p := range flags.Args() {
+		f, err := fsys.Open(resolve(p))
+		if err != nil {
+			warn(err)
+			continue
+		}
+		_, err = io.Copy(os.Stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeNode is a file in a tree.
+type treeNode struct {
+	*entry
+	Contents []*treeNode `json:"contents,omitempty"`
+}
+
+// walkTree walks root, returning the tree. Dotfiles are skipped unless all is
+// set, and directories deeper than level are not listed if it is non-zero.
+func walkTree(fsys *adbfs.FS, root string, all bool, level int) (*treeNode, error) {
+	nodes := map[string]*treeNode{}
+	var top *treeNode
+	err := fsys.Walk(context.Background(), root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			warn(err)
+			return nil
+		}
+		if name != root && !all && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			warn(err)
+			return nil
+		}
+		n := &treeNode{entry: newEntry(name, fi)}
+		if name == root {
+			n.Name = devicePath(root)
+			top = n
+		} else if p := nodes[path.Dir(name)]; p != nil {
+			p.Contents = append(p.Contents, n)
+		}
+		if d.IsDir() {
+			nodes[name] = n
+			if level > 0 && depth(root, name) >= level {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		slices.SortFunc(n.Contents, func(a, b *treeNode) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return top, nil
+}
+
+func tree(args []string) error {
+	flags := newFlagSet("tree")
+	all := flags.Bool("a", false, "include entries starting with a dot")
+	level := flags.Int("L", 0, "maximum depth (0 for unlimited)")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	var ndir, nfile int
+	for _, p := range paths {
+		root := resolve(p)
+		t, err := walkTree(fsys, root, *all, *level)
+		if err != nil {
+			warn(err)
+			continue
+		}
+		if *jsonFlag {
+			if err := printJSON(t); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(t.Name)
+		treePrint(t, "", &ndir, &nfile)
+	}
+	if !*jsonFlag {
+		fmt.Printf("\n%d directories, %d files\n", ndir, nfile)
+	}
+	return nil
+}
+
+func treePrint(n *treeNode, prefix string, ndir, nfile *int) {
+	for i, c := range n.Contents {
+		branch, next := "├── ", "│   "
+		if i == len(n.Contents)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Println(prefix + branch + c.Name)
+		if c.IsDir {
+			*ndir++
+			treePrint(c, prefix+next, ndir, nfile)
+		} else {
+			*nfile++
+		}
+	}
+}
+
+// depth returns the number of path elements in name under root.
+func depth(root, name string) int {
+	if name == root {
+		return 0
+	}
+	if root == "." {
+		return strings.Count(name, "/") + 1
+	}
+	return strings.Count(name[len(root):], "/")
+}
+
+// findFilter matches files for find.
+type findFilter struct {
+	Name     string    // shell pattern for the base name
+	Type     byte      // f, d, l, or zero
+	Newer    time.Time // modified after
+	Size     int64     // size in SizeUnit, or -1
+	SizeCmp  int       // 1 for more than, -1 for less than
+	SizeUnit int64
+}
+
+// parseSize parses a find -size argument.
+func (f *findFilter) parseSize(s string) error {
+	f.SizeCmp, f.SizeUnit = 0, 1
+	switch {
+	case strings.HasPrefix(s, "+"):
+		f.SizeCmp, s = 1, s[1:]
+	case strings.HasPrefix(s, "-"):
+		f.SizeCmp, s = -1, s[1:]
+	}
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'c':
+			f.SizeUnit, s = 1, s[:len(s)-1]
+		case 'k':
+			f.SizeUnit, s = 1<<10, s[:len(s)-1]
+		case 'M':
+			f.SizeUnit, s = 1<<20, s[:len(s)-1]
+		case 'G':
+			f.SizeUnit, s = 1<<30, s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return err
+	}
+	f.Size = int64(n)
+	return nil
+}
+
+func (f *findFilter) match(fi fs.FileInfo) bool {
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, fi.Name()); !ok {
+			return false
+		}
+	}
+	switch f.Type {
+	case 'f':
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+	case 'd':
+		if !fi.IsDir() {
+			return false
+		}
+	case 'l':
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			return false
+		}
+	}
+	if !f.Newer.IsZero() && !fi.ModTime().After(f.Newer) {
+		return false
+	}
+	if f.Size >= 0 {
+		// rounded up like find
+		n := (fi.Size() + f.SizeUnit - 1) / f.SizeUnit
+		switch {
+		case f.SizeCmp > 0:
+			return n > f.Size
+		case f.SizeCmp < 0:
+			return n < f.Size
+		default:
+			return n == f.Size
+		}
+	}
+	return true
+}
+
+func find(args []string) error {
+	filter := findFilter{Size: -1}
+	flags := newFlagSet("find")
+	flags.StringVar(&filter.Name, "name", "", "match the base name against a shell `pattern`")
+	flags.Func("type", "match the file `type` (f, d, or l)", func(s string) error {
+		switch s {
+		case "f", "d", "l":
+			filter.Type = s[0]
+			return nil
+		}
+		return fmt.Errorf("unknown type %q", s)
+	})
+	newer := flags.String("newer", "", "match files modified more recently than `path` on the device")
+	maxDepth := flags.Int("maxdepth", -1, "descend at most `n` levels")
+	flags.Func("size", "match the size in `[+|-]n[c|k|M|G]` (bytes by default), rounded up, where + is more than and - is less than", filter.parseSize)
+	flags.Parse(args)
+
+	if _, err := path.Match(filter.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern: %w", err)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	if *newer != "" {
+		fi, err := fsys.Stat(resolve(*newer))
+		if err != nil {
+			return err
+		}
+		filter.Newer = fi.ModTime()
+	}
+
+	for _, p := range paths {
+		root := resolve(p)
+		err := fsys.Walk(context.Background(), root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if name == root {
+					return err
+				}
+				warn(err)
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				warn(err)
+				return nil
+			}
+			if filter.match(fi) {
+				if *jsonFlag {
+					if err := printJSON(newEntry(name, fi)); err != nil {
+						return err
+					}
+				} else {
+					fmt.Println(devicePath(name))
+				}
+			}
+			if d.IsDir() && *maxDepth >= 0 && depth(root, name) >= *maxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			warn(err)
+		}
+	}
+	return nil
+}
+
+func du(args []string) error {
+	flags := newFlagSet("du")
+	summarize := flags.Bool("s", false, "only show the total for each path")
+	bytes := flags.Bool("b", false, "show sizes in bytes")
+	human := flags.Bool("h", false, "show sizes in human-readable units")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s du %s\n\nSizes are the apparent size of the files, in KiB unless -b or -h is used.\n\n", os.Args[0], commands["du"].Usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	for _, p := range paths {
+		root := resolve(p)
+		var (
+			dirs  []string
+			total = map[string]int64{}
+		)
+		err := fsys.Walk(context.Background(), root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if name == root {
+					return err
+				}
+				warn(err)
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				warn(err)
+				return nil
+			}
+			if d.IsDir() {
+				dirs = append(dirs, name)
+			}
+			for dir := name; ; dir = path.Dir(dir) {
+				total[dir] += fi.Size()
+				if dir == root || dir == "." {
+					break
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			warn(err)
+			continue
+		}
+		if *summarize || len(dirs) == 0 {
+			dirs = []string{root}
+		}
+
+		// children before parents, like du
+		slices.SortFunc(dirs, func(a, b string) int {
+			as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+			for i := range min(len(as), len(bs)) {
+				if c := strings.Compare(as[i], bs[i]); c != 0 {
+					return c
+				}
+			}
+			return len(bs) - len(as)
+		})
+		for _, dir := range dirs {
+			size := total[dir]
+			switch {
+			case *jsonFlag:
+				if err := printJSON(struct {
+					Path string `json:"path"`
+					Size int64  `json:"size"`
+				}{devicePath(dir), size}); err != nil {
+					return err
+				}
+			case *bytes:
+				fmt.Printf("%d\t%s\n", size, devicePath(dir))
+			case *human:
+				fmt.Printf("%s\t%s\n", humanSize(size), devicePath(dir))
+			default:
+				fmt.Printf("%d\t%s\n", (size+1023)/1024, devicePath(dir))
+			}
+		}
+	}
+	return nil
+}
+
+func humanSize(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	f, u := float64(n), ""
+	for _, u = range []string{"K", "M", "G", "T", "P"} {
+		if f /= 1024; f < 1024 {
+			break
+		}
+	}
+	if f < 10 {
+		return strconv.FormatFloat(f, 'f', 1, 64) + u
+	}
+	return strconv.FormatFloat(f, 'f', 0, 64) + u
+}
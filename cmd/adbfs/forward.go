@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["forward"] = &command{
+		Usage: "[-list] [-remove] [-remove-all] [local [remote]]",
+		Short: "forward host sockets to the device",
+		Run: forwardCommand("forward", forwardFuncs{
+			Add:       (*adbfs.FS).Forward,
+			List:      (*adbfs.FS).ListForwards,
+			Remove:    (*adbfs.FS).RemoveForward,
+			RemoveAll: (*adbfs.FS).RemoveAllForwards,
+		}),
+	}
+	commands["reverse"] = &command{
+		Usage: "[-list] [-remove] [-remove-all] [remote [local]]",
+		Short: "forward device sockets to the host",
+		Run: forwardCommand("reverse", forwardFuncs{
+			Add:       (*adbfs.FS).ReverseForward,
+			List:      (*adbfs.FS).ListReverseForwards,
+			Remove:    (*adbfs.FS).RemoveReverseForward,
+			RemoveAll: (*adbfs.FS).RemoveAllReverseForwards,
+			Reverse:   true,
+		}),
+	}
+}
+
+type forwardFuncs struct {
+	Add       func(*adbfs.FS, string, string) (string, error)
+	List      func(*adbfs.FS) ([]adbfs.PortForward, error)
+	Remove    func(*adbfs.FS, string) error
+	RemoveAll func(*adbfs.FS) error
+	Reverse   bool // Add takes the device socket first
+}
+
+// forwardEntry is the JSON output for a forwarding rule.
+type forwardEntry struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+func forwardCommand(name string, fn forwardFuncs) func(args []string) error {
+	return func(args []string) error {
+		flags := newFlagSet(name)
+		list := flags.Bool("list", false, "list rules")
+		remove := flags.Bool("remove", false, "remove the rule for the socket")
+		removeAll := flags.Bool("remove-all", false, "remove all rules")
+		flags.Parse(args)
+
+		var nargs int
+		switch {
+		case *list, *removeAll:
+			nargs = 0
+		case *remove:
+			nargs = 1
+		default:
+			nargs = 2
+		}
+		if flags.NArg() != nargs {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		fsys, err := connect("")
+		if err != nil {
+			return err
+		}
+		defer fsys.Close()
+
+		switch {
+		case *list:
+			fwd, err := fn.List(fsys)
+			if err != nil {
+				return err
+			}
+			for _, f := range fwd {
+				if *jsonFlag {
+					if err := printJSON(forwardEntry{f.Local, f.Remote}); err != nil {
+						return err
+					}
+				} else {
+					fmt.Println(f.Local, f.Remote)
+				}
+			}
+			return nil
+		case *removeAll:
+			return fn.RemoveAll(fsys)
+		case *remove:
+			return fn.Remove(fsys, flags.Arg(0))
+		}
+		spec, err := fn.Add(fsys, flags.Arg(0), flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		if *jsonFlag {
+			if fn.Reverse {
+				return printJSON(forwardEntry{flags.Arg(1), spec})
+			}
+			return printJSON(forwardEntry{spec, flags.Arg(1)})
+		}
+		if spec != flags.Arg(0) {
+			fmt.Println(spec)
+		}
+		return nil
+	}
+}
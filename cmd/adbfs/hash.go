@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+func init() {
+	commands["hash"] = &command{
+		Usage: "[-a algorithm] path...",
+		Short: "compute checksums of files on the device",
+		Run:   hash,
+	}
+}
+
+var hashAlgorithms = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
+	"sha1":   crypto.SHA1,
+	"sha224": crypto.SHA224,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+func hash(args []string) error {
+	flags := newFlagSet("hash")
+	algoName := flags.String("a", "sha256", "hash `algorithm` (md5, sha1, sha224, sha256, sha384, or sha512)")
+	flags.Parse(args)
+
+	algo, ok := hashAlgorithms[strings.ToLower(*algoName)]
+	if !ok {
+		return fmt.Errorf("unsupported hash algorithm %q", *algoName)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	names := make([]string, flags.NArg())
+	for i, p := range flags.Args() {
+		names[i] = resolve(p)
+	}
+	fis, errs := fsys.StatMany(names)
+	for i, fi := range fis {
+		if errs[i] != nil {
+			warn(errs[i])
+			continue
+		}
+		var sums map[string][]byte
+		if fi.IsDir() {
+			sums, err = fsys.HashTree(context.Background(), names[i], algo)
+			if err != nil {
+				warn(err)
+				continue
+			}
+		} else {
+			sum, err := fsys.Hash(names[i], algo)
+			if err != nil {
+				warn(err)
+				continue
+			}
+			sums = map[string][]byte{names[i]: sum}
+		}
+		files := make([]string, 0, len(sums))
+		for name := range sums {
+			files = append(files, name)
+		}
+		slices.Sort(files)
+		for _, name := range files {
+			if *jsonFlag {
+				if err := printJSON(struct {
+					Path      string `json:"path"`
+					Algorithm string `json:"algorithm"`
+					Hash      string `json:"hash"`
+				}{devicePath(name), strings.ToLower(*algoName), hex.EncodeToString(sums[name])}); err != nil {
+					return err
+				}
+			} else {
+				fmt.Printf("%x  %s\n", sums[name], devicePath(name))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["input"] = &command{
+		Usage: "tap x y | swipe x1 y1 x2 y2 [ms] | text string | keyevent key...",
+		Short: "inject input events",
+		Run:   input,
+	}
+	commands["uidump"] = &command{
+		Usage: "",
+		Short: "dump the UI hierarchy of the current window",
+		Run:   uidump,
+	}
+	commands["start"] = &command{
+		Usage: "component",
+		Short: "start an activity and wait for it to launch",
+		Run: inputCommand("start", 1, func(in *adbfs.Input, args []string) error {
+			return in.StartActivity(context.Background(), args[0])
+		}),
+	}
+	commands["force-stop"] = &command{
+		Usage: "package...",
+		Short: "force-stop packages",
+		Run: inputCommand("force-stop", -1, func(in *adbfs.Input, args []string) error {
+			for _, pkg := range args {
+				if err := in.ForceStop(context.Background(), pkg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+}
+
+// inputCommand returns a command which calls fn with the arguments, which must
+// have the specified length (or at least one if negative).
+func inputCommand(name string, nargs int, fn func(in *adbfs.Input, args []string) error) func(args []string) error {
+	return func(args []string) error {
+		flags := newFlagSet(name)
+		flags.Parse(args)
+
+		if (nargs < 0 && flags.NArg() == 0) || (nargs >= 0 && flags.NArg() != nargs) {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		fsys, err := connect("")
+		if err != nil {
+			return err
+		}
+		defer fsys.Close()
+
+		in := fsys.Input()
+		defer in.Close()
+
+		return fn(in, flags.Args())
+	}
+}
+
+func input(args []string) error {
+	return inputCommand("input", -1, func(in *adbfs.Input, args []string) error {
+		ctx := context.Background()
+		n := make([]int, len(args)-1)
+		switch args[0] {
+		case "tap", "swipe":
+			for i, arg := range args[1:] {
+				v, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("invalid number %q", arg)
+				}
+				n[i] = v
+			}
+		}
+		switch {
+		case args[0] == "tap" && len(n) == 2:
+			return in.Tap(ctx, n[0], n[1])
+		case args[0] == "swipe" && len(n) == 4:
+			return in.Swipe(ctx, n[0], n[1], n[2], n[3], 0)
+		case args[0] == "swipe" && len(n) == 5:
+			return in.Swipe(ctx, n[0], n[1], n[2], n[3], time.Duration(n[4])*time.Millisecond)
+		case args[0] == "text" && len(n) != 0:
+			return in.Text(ctx, strings.Join(args[1:], " "))
+		case args[0] == "keyevent" && len(n) != 0:
+			return in.KeyEvent(ctx, args[1:]...)
+		}
+		fmt.Fprintf(os.Stderr, "usage: %s input %s\n", os.Args[0], commands["input"].Usage)
+		os.Exit(2)
+		return nil
+	})(args)
+}
+
+// uiNodeEntry is the JSON output for a UI node.
+type uiNodeEntry struct {
+	Attr     map[string]string `json:"attr"`
+	Children []uiNodeEntry     `json:"children,omitempty"`
+}
+
+func newUINodeEntry(n *adbfs.UINode) uiNodeEntry {
+	e := uiNodeEntry{Attr: n.Attr}
+	for _, c := range n.Children {
+		e.Children = append(e.Children, newUINodeEntry(c))
+	}
+	return e
+}
+
+func uidump(args []string) error {
+	return inputCommand("uidump", 0, func(in *adbfs.Input, args []string) error {
+		root, err := in.DumpUI(context.Background())
+		if err != nil {
+			return err
+		}
+		if *jsonFlag {
+			return printJSON(newUINodeEntry(root))
+		}
+		var walk func(n *adbfs.UINode, depth int)
+		walk = func(n *adbfs.UINode, depth int) {
+			if depth >= 0 {
+				var b strings.Builder
+				b.WriteString(strings.Repeat("  ", depth))
+				b.WriteString(n.Class)
+				if n.ResourceID != "" {
+					b.WriteString(" #" + n.ResourceID)
+				}
+				if n.Text != "" {
+					b.WriteString(" " + strconv.Quote(n.Text))
+				}
+				if n.ContentDesc != "" {
+					b.WriteString(" desc=" + strconv.Quote(n.ContentDesc))
+				}
+				b.WriteString(" " + n.Bounds.String())
+				if n.Clickable {
+					b.WriteString(" clickable")
+				}
+				fmt.Println(b.String())
+			}
+			for _, c := range n.Children {
+				walk(c, depth+1)
+			}
+		}
+		walk(root, -1)
+		return nil
+	})(args)
+}
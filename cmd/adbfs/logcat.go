@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["logcat"] = &command{
+		Usage: "[-b buffer]... [-d] [-T count|time] [filterspec...]",
+		Short: "show device logs",
+		Run:   logcat,
+	}
+}
+
+// logEntry is the JSON output for a log message.
+type logEntry struct {
+	Time     time.Time `json:"time"`
+	PID      int32     `json:"pid"`
+	TID      uint32    `json:"tid"`
+	UID      int32     `json:"uid"`
+	Buffer   string    `json:"buffer,omitempty"`
+	Priority string    `json:"priority"`
+	Tag      string    `json:"tag"`
+	Message  string    `json:"message"`
+	Data     []byte    `json:"data,omitempty"`
+}
+
+func logcat(args []string) error {
+	flags := newFlagSet("logcat")
+	var opts adbfs.LogcatOptions
+	flags.Func("b", "read from the log `buffer` (can be repeated)", func(s string) error {
+		opts.Buffers = append(opts.Buffers, s)
+		return nil
+	})
+	flags.BoolVar(&opts.Dump, "d", false, "exit once the existing messages have been read")
+	flags.Func("T", "only show the most recent `count` messages, or messages since a time (RFC 3339 or Unix seconds)", func(s string) error {
+		if n, err := strconv.Atoi(s); err == nil {
+			opts.Tail = n
+			return nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			opts.Since = time.Unix(0, int64(f*1e9))
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		opts.Since = t
+		return err
+	})
+	flags.Parse(args)
+	opts.Filters = flags.Args()
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	entries, errc := fsys.Logcat(ctx, &opts)
+	for e := range entries {
+		if *jsonFlag {
+			if err := printJSON(logEntry{e.Time, e.PID, e.TID, e.UID, e.Buffer, e.Priority.String(), e.Tag, e.Message, e.Data}); err != nil {
+				return err
+			}
+			continue
+		}
+		msg := e.Message
+		if e.Data != nil {
+			msg = fmt.Sprintf("[binary %d bytes]", len(e.Data))
+		}
+		prefix := fmt.Sprintf("%s %5d %5d %s %s: ", e.Time.Format("01-02 15:04:05.000"), e.PID, e.TID, e.Priority, e.Tag)
+		for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+			fmt.Println(prefix + line)
+		}
+	}
+	if err := <-errc; err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
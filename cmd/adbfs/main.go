@@ -0,0 +1,230 @@
+// Command adbfs provides access to the filesystem of an ADB device.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+// command is an adbfs subcommand.
+type command struct {
+	Usage string // arguments
+	Short string // one-line description
+	Run   func(args []string) error
+}
+
+// commands contains the available subcommands. Commands which are only
+// available on some platforms are added in init.
+var commands = map[string]*command{}
+
+var (
+	serialFlag = flag.String("s", "", "use the device with the given `serial` (default $ANDROID_SERIAL, or the only device)")
+	hostFlag   = flag.String("H", "", "ADB server `host:port` (default $ANDROID_ADB_SERVER_ADDRESS:$ANDROID_ADB_SERVER_PORT, or localhost:5037)")
+	jsonFlag   = flag.Bool("json", false, "output JSON lines")
+)
+
+// exitCode is set to 1 if a non-fatal error was printed using warn.
+var (
+	exitCode int
+	warnMu   sync.Mutex
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] command [arguments]\n\ncommands:\n", os.Args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-8s %s\n", name, commands[name].Short)
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "\noptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := cmd.Run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "adbfs %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+	os.Exit(exitCode)
+}
+
+// newFlagSet creates a flag set for the named command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n", os.Args[0], name, commands[name].Usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// warn prints a non-fatal error. It is safe for concurrent use.
+func warn(err error) {
+	warnMu.Lock()
+	defer warnMu.Unlock()
+	fmt.Fprintf(os.Stderr, "adbfs %s: %v\n", flag.Arg(0), err)
+	exitCode = 1
+}
+
+// adbAddr returns the address of the ADB server.
+func adbAddr() string {
+	host, port := "localhost", "5037"
+	if v := os.Getenv("ANDROID_ADB_SERVER_ADDRESS"); v != "" {
+		host = v
+	}
+	if v := os.Getenv("ANDROID_ADB_SERVER_PORT"); v != "" {
+		port = strings.TrimPrefix(v, ":")
+	}
+	if v := *hostFlag; v != "" {
+		if h, p, err := net.SplitHostPort(v); err == nil {
+			host, port = h, p
+		} else {
+			host = v
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// connect connects to the device with the specified serial, or the one
+// specified by the flags if empty.
+func connect(serial string) (*adbfs.FS, error) {
+	if serial == "" {
+		serial = *serialFlag
+	}
+	if serial == "" {
+		serial = os.Getenv("ANDROID_SERIAL")
+	}
+	return adbfs.Connect(adbAddr(), serial)
+}
+
+// resolve converts a device path into a fs path.
+func resolve(name string) string {
+	if name = strings.TrimPrefix(path.Clean("/"+name), "/"); name == "" {
+		name = "."
+	}
+	return name
+}
+
+// devicePath converts a fs path into a device path.
+func devicePath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+// entry is the JSON output for a file.
+type entry struct {
+	Path    string     `json:"path"`
+	Name    string     `json:"name"`
+	Size    int64      `json:"size"`
+	Mode    string     `json:"mode"`
+	ModTime time.Time  `json:"mod_time"`
+	IsDir   bool       `json:"is_dir"`
+	Uid     uint32     `json:"uid"`
+	Gid     uint32     `json:"gid"`
+	Ino     uint64     `json:"ino,omitempty"`
+	Dev     uint64     `json:"dev,omitempty"`
+	Nlink   uint32     `json:"nlink,omitempty"`
+	Atime   *time.Time `json:"atime,omitempty"`
+	Ctime   *time.Time `json:"ctime,omitempty"`
+}
+
+func newEntry(name string, fi fs.FileInfo) *entry {
+	e := &entry{
+		Path:    devicePath(name),
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    modeString(fi),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+	if st, ok := fi.Sys().(*adbfs.FileStat); ok {
+		e.Uid, e.Gid = st.Uid, st.Gid
+		e.Ino, e.Dev, e.Nlink = st.Ino, st.Dev, st.Nlink
+		if st.Atime != 0 {
+			t := time.Unix(st.Atime, 0)
+			e.Atime = &t
+		}
+		if st.Ctime != 0 {
+			t := time.Unix(st.Ctime, 0)
+			e.Ctime = &t
+		}
+	}
+	return e
+}
+
+// printJSON writes v as a single line of JSON.
+func printJSON(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(buf, '\n'))
+	return err
+}
+
+// modeString formats the mode like ls -l.
+func modeString(fi fs.FileInfo) string {
+	m := fi.Mode()
+	b := []byte("?rwxrwxrwx")
+	switch m.Type() {
+	case 0:
+		b[0] = '-'
+	case fs.ModeDir:
+		b[0] = 'd'
+	case fs.ModeSymlink:
+		b[0] = 'l'
+	case fs.ModeNamedPipe:
+		b[0] = 'p'
+	case fs.ModeSocket:
+		b[0] = 's'
+	case fs.ModeDevice | fs.ModeCharDevice:
+		b[0] = 'c'
+	case fs.ModeDevice:
+		b[0] = 'b'
+	}
+	for i := range 9 {
+		if m&(1<<(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+	for _, x := range []struct {
+		bit fs.FileMode
+		i   int
+		c   byte
+	}{
+		{fs.ModeSetuid, 3, 's'},
+		{fs.ModeSetgid, 6, 's'},
+		{fs.ModeSticky, 9, 't'},
+	} {
+		if m&x.bit != 0 {
+			if b[x.i] == '-' {
+				b[x.i] = x.c - 'a' + 'A'
+			} else {
+				b[x.i] = x.c
+			}
+		}
+	}
+	return string(b)
+}
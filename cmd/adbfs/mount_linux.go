@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pgaskin/go-adbfs/fusefs"
+)
+
+func init() {
+	commands["mount"] = &command{
+		Usage: "[options] [serial] mountpoint",
+		Short: "mount a device filesystem using FUSE",
+		Run:   mount,
+	}
+}
+
+func mount(args []string) error {
+	var opts fusefs.Options
+	fs := newFlagSet("mount")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "attribute and entry cache `duration` (default 1s, negative to disable)")
+	fs.DurationVar(&opts.NegativeTimeout, "negative-timeout", 0, "failed lookup cache `duration`")
+	fs.BoolVar(&opts.ReadOnly, "ro", false, "mount read-only")
+	fs.BoolVar(&opts.AllowOther, "allow-other", false, "allow other users to access the mount")
+	fs.BoolVar(&opts.Debug, "debug", false, "log FUSE requests")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var serial, mountpoint string
+	if fs.NArg() == 2 {
+		serial, mountpoint = fs.Arg(0), fs.Arg(1)
+	} else {
+		mountpoint = fs.Arg(0)
+	}
+
+	fsys, err := connect(serial)
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	srv, err := fusefs.Mount(fsys, mountpoint, &opts)
+	if err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range sig {
+			if err := srv.Unmount(); err != nil {
+				fmt.Fprintf(os.Stderr, "adbfs mount: unmount: %v\n", err)
+			}
+		}
+	}()
+
+	srv.Wait()
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pgaskin/go-adbfs/p9fs"
+)
+
+func init() {
+	commands["9p"] = &command{
+		Usage: "[options] [serial] address",
+		Short: "serve a device filesystem over 9P2000.L",
+		Run:   serve9p,
+	}
+}
+
+func serve9p(args []string) error {
+	var opts p9fs.Options
+	fs := newFlagSet("9p")
+	fs.BoolVar(&opts.ReadOnly, "ro", false, "reject modifications")
+	fs.Func("msize", "maximum message `size` (default 512 KiB)", func(s string) error {
+		_, err := fmt.Sscan(s, &opts.MaxMessageSize)
+		return err
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s 9p %s\n\nThe address is a TCP host:port, or a unix socket path if it contains a slash.\n\n", os.Args[0], commands["9p"].Usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var serial, addr string
+	if fs.NArg() == 2 {
+		serial, addr = fs.Arg(0), fs.Arg(1)
+	} else {
+		addr = fs.Arg(0)
+	}
+
+	fsys, err := connect(serial)
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	fmt.Fprintf(os.Stderr, "adbfs 9p: listening on %s\n", l.Addr())
+	return p9fs.New(fsys, &opts).Serve(l)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["install"] = &command{
+		Usage: "[-r] [-d] [-g] [-t] [-user id] apk...",
+		Short: "install an app from one or more (split) APKs",
+		Run:   install,
+	}
+	commands["uninstall"] = &command{
+		Usage: "[-k] package...",
+		Short: "uninstall packages",
+		Run:   uninstall,
+	}
+	commands["packages"] = &command{
+		Usage: "[-s] [-3] [-e] [-d] [-user id]",
+		Short: "list installed packages",
+		Run:   packages,
+	}
+}
+
+func install(args []string) error {
+	flags := newFlagSet("install")
+	var opts adbfs.InstallOptions
+	flags.BoolVar(&opts.Replace, "r", false, "replace an existing app")
+	flags.BoolVar(&opts.Downgrade, "d", false, "allow version code downgrade")
+	flags.BoolVar(&opts.GrantPermissions, "g", false, "grant all runtime permissions")
+	flags.BoolVar(&opts.Test, "t", false, "allow test packages")
+	flags.StringVar(&opts.User, "user", "", "install for the specified user `id`")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	if err := fsys.InstallFiles(context.Background(), flags.Args(), &opts); err != nil {
+		return err
+	}
+	if !*jsonFlag {
+		fmt.Println("Success")
+	}
+	return nil
+}
+
+func uninstall(args []string) error {
+	flags := newFlagSet("uninstall")
+	keep := flags.Bool("k", false, "keep the data and cache directories")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	for _, pkg := range flags.Args() {
+		if err := fsys.Uninstall(context.Background(), pkg, *keep); err != nil {
+			warn(fmt.Errorf("%s: %w", pkg, err))
+		}
+	}
+	return nil
+}
+
+// packageEntry is the JSON output for a package.
+type packageEntry struct {
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	UID         int    `json:"uid"`
+	VersionCode int64  `json:"versionCode"`
+	Installer   string `json:"installer,omitempty"`
+}
+
+func packages(args []string) error {
+	flags := newFlagSet("packages")
+	var opts adbfs.ListPackagesOptions
+	flags.BoolVar(&opts.System, "s", false, "only list system packages")
+	flags.BoolVar(&opts.ThirdParty, "3", false, "only list third-party packages")
+	flags.BoolVar(&opts.Enabled, "e", false, "only list enabled packages")
+	flags.BoolVar(&opts.Disabled, "d", false, "only list disabled packages")
+	flags.StringVar(&opts.User, "user", "", "list packages for the specified user `id`")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	pkgs, err := fsys.ListPackages(context.Background(), &opts)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		if *jsonFlag {
+			if err := printJSON(packageEntry{p.Name, p.Path, p.UID, p.VersionCode, p.Installer}); err != nil {
+				return err
+			}
+			continue
+		}
+		uid, version := "-", "-"
+		if p.UID != -1 {
+			uid = fmt.Sprint(p.UID)
+		}
+		if p.VersionCode != -1 {
+			version = fmt.Sprint(p.VersionCode)
+		}
+		rows = append(rows, []string{p.Name, version, uid, p.Path})
+	}
+	if !*jsonFlag {
+		printColumns(rows, []bool{false, true, true, false})
+	}
+	return nil
+}
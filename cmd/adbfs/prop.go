@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+)
+
+func init() {
+	commands["getprop"] = &command{
+		Usage: "[-w] [name]",
+		Short: "show system properties",
+		Run:   getprop,
+	}
+}
+
+// propEntry is the JSON output for a property.
+type propEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func getprop(args []string) error {
+	flags := newFlagSet("getprop")
+	watch := flags.Bool("w", false, "wait for the property to change, then show the new value")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || (*watch && flags.NArg() != 1) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var props map[string]string
+	switch name := flags.Arg(0); {
+	case *watch:
+		v, err := fsys.WatchProp(ctx, name)
+		if err != nil {
+			return err
+		}
+		props = map[string]string{name: v}
+	case name != "":
+		v, err := fsys.GetProp(name)
+		if err != nil {
+			return err
+		}
+		props = map[string]string{name: v}
+	default:
+		if props, err = fsys.Properties(ctx); err != nil {
+			return err
+		}
+	}
+	return printProps(props, flags.NArg() == 0)
+}
+
+// printProps prints the properties, only printing the value if there is a
+// single property and !all.
+func printProps(props map[string]string, all bool) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		switch {
+		case *jsonFlag:
+			if err := printJSON(propEntry{name, props[name]}); err != nil {
+				return err
+			}
+		case !all:
+			fmt.Println(props[name])
+		default:
+			fmt.Printf("[%s]: [%s]\n", name, props[name])
+		}
+	}
+	return nil
+}
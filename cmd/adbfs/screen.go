@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["screenshot"] = &command{
+		Usage: "[path]",
+		Short: "save a PNG screenshot (to stdout if no path)",
+		Run:   screenshot,
+	}
+	commands["screenrecord"] = &command{
+		Usage: "[-size WxH] [-bit-rate n] [-time-limit d] [-display id] [path]",
+		Short: "record the screen as raw H.264 (to stdout if no path)",
+		Run:   screenrecord,
+	}
+}
+
+// createOutput creates the named file, or returns stdout if name is empty.
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func screenshot(args []string) error {
+	flags := newFlagSet("screenshot")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	buf, err := fsys.ScreenshotPNG(context.Background())
+	if err != nil {
+		return err
+	}
+	f, err := createOutput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func screenrecord(args []string) error {
+	flags := newFlagSet("screenrecord")
+	var opts adbfs.ScreenRecordOptions
+	flags.Func("size", "video size (`WxH`)", func(s string) error {
+		_, err := fmt.Sscanf(s, "%dx%d", &opts.Width, &opts.Height)
+		return err
+	})
+	flags.IntVar(&opts.BitRate, "bit-rate", 0, "video bit rate in bits per second")
+	flags.DurationVar(&opts.TimeLimit, "time-limit", 0, "maximum recording duration (default 3m)")
+	flags.StringVar(&opts.Display, "display", "", "record the display with the specified `id`")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	f, err := createOutput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = fsys.ScreenRecord(ctx, f, &opts)
+	if err1 := f.Close(); err == nil || err == ctx.Err() {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/pgaskin/go-adbfs/sftpfs"
+)
+
+func init() {
+	commands["sftp"] = &command{
+		Usage: "[options] [serial]",
+		Short: "serve a device filesystem over SFTP",
+		Run:   serveSFTP,
+	}
+}
+
+func serveSFTP(args []string) error {
+	var opts sftpfs.Options
+	fs := newFlagSet("sftp")
+	fs.BoolVar(&opts.ReadOnly, "ro", false, "reject modifications")
+	listen := fs.String("listen", "", "serve raw SFTP connections on a TCP `address` instead of stdin/stdout (e.g., for sshfs -o directport)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s sftp %s\n\nBy default, SFTP is served over stdin/stdout, which is suitable for use as a SSH subsystem.\n\n", os.Args[0], commands["sftp"].Usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	if *listen == "" {
+		srv := sftpfs.NewServer(stdio{}, fsys, &opts)
+		if err := srv.Serve(); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	fmt.Fprintf(os.Stderr, "adbfs sftp: listening on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			srv := sftpfs.NewServer(conn, fsys, &opts)
+			if err := srv.Serve(); err != nil && err != io.EOF {
+				fmt.Fprintf(os.Stderr, "adbfs sftp: %s: %v\n", conn.RemoteAddr(), err)
+			}
+			srv.Close()
+		}()
+	}
+}
+
+// stdio is a io.ReadWriteCloser for stdin/stdout.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdio) Close() error {
+	return os.Stdout.Close()
+}
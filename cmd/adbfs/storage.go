@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["df"] = &command{
+		Usage: "[-h] [path...]",
+		Short: "show free space",
+		Run:   df,
+	}
+	commands["mounts"] = &command{
+		Usage: "",
+		Short: "show mounted filesystems",
+		Run:   mounts,
+	}
+}
+
+func df(args []string) error {
+	flags := newFlagSet("df")
+	human := flags.Bool("h", false, "show sizes in human-readable units")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s df %s\n\nIf no paths are specified, all block device mounts are shown.\n\n", os.Args[0], commands["df"].Usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		ms, err := fsys.Mounts()
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if strings.HasPrefix(m.Device, "/dev/") {
+				paths = append(paths, m.Path)
+			}
+		}
+	}
+
+	size := func(n int64) string {
+		if *human {
+			return humanSize(n)
+		}
+		return strconv.FormatInt((n+1023)/1024, 10)
+	}
+	rows := [][]string{{"Filesystem", "Type", "Size", "Used", "Avail", "Use%", "Mounted on"}}
+	if !*human {
+		rows[0][2] = "1K-blocks"
+	}
+	for _, p := range paths {
+		st, err := fsys.StatFS(resolve(p))
+		if err != nil {
+			warn(err)
+			continue
+		}
+		if *jsonFlag {
+			if err := printJSON(newStatFSEntry(devicePath(resolve(p)), st)); err != nil {
+				return err
+			}
+			continue
+		}
+		used := st.Total - st.Free
+		pct := "-"
+		if d := used + st.Available; d > 0 {
+			pct = strconv.FormatInt((used*100+d-1)/d, 10) + "%"
+		}
+		rows = append(rows, []string{st.Device, st.Type, size(st.Total), size(used), size(st.Available), pct, st.Path})
+	}
+	if !*jsonFlag {
+		printColumns(rows, []bool{false, false, true, true, true, true, false})
+	}
+	return nil
+}
+
+// statFSEntry is the JSON output for a filesystem.
+type statFSEntry struct {
+	Path       string   `json:"path"`
+	Device     string   `json:"device"`
+	MountPoint string   `json:"mount_point"`
+	Type       string   `json:"type"`
+	Options    []string `json:"options"`
+	ReadOnly   bool     `json:"read_only"`
+	BlockSize  int64    `json:"block_size"`
+	Total      int64    `json:"total"`
+	Free       int64    `json:"free"`
+	Available  int64    `json:"available"`
+	Files      int64    `json:"files"`
+	FilesFree  int64    `json:"files_free"`
+}
+
+func newStatFSEntry(name string, st *adbfs.FSStat) *statFSEntry {
+	return &statFSEntry{
+		Path:       name,
+		Device:     st.Device,
+		MountPoint: st.Path,
+		Type:       st.Type,
+		Options:    st.Options,
+		ReadOnly:   st.ReadOnly(),
+		BlockSize:  st.BlockSize,
+		Total:      st.Total,
+		Free:       st.Free,
+		Available:  st.Available,
+		Files:      st.Files,
+		FilesFree:  st.FilesFree,
+	}
+}
+
+func mounts(args []string) error {
+	flags := newFlagSet("mounts")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	ms, err := fsys.Mounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if *jsonFlag {
+			if err := printJSON(struct {
+				Device   string   `json:"device"`
+				Path     string   `json:"path"`
+				Type     string   `json:"type"`
+				Options  []string `json:"options"`
+				ReadOnly bool     `json:"read_only"`
+			}{m.Device, m.Path, m.Type, m.Options, m.ReadOnly()}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("%s on %s type %s (%s)\n", m.Device, m.Path, m.Type, strings.Join(m.Options, ","))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	_ "crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+func init() {
+	commands["pull"] = &command{
+		Usage: "[-a] [-j n] [-verify] remote... local",
+		Short: "copy files from the device",
+		Run:   pull,
+	}
+	commands["push"] = &command{
+		Usage: "[-j n] [-verify] [-checkspace] local... remote",
+		Short: "copy files to the device",
+		Run:   push,
+	}
+}
+
+// transfer runs file copies concurrently.
+type transfer struct {
+	wg    sync.WaitGroup
+	sem   chan struct{}
+	start time.Time
+	files atomic.Int64
+	bytes atomic.Int64
+
+	queueMu  sync.Mutex
+	queue    []func() (int64, error)
+	queueRun bool
+
+	sumsMu sync.Mutex
+	sums   map[string][]byte // for verification
+}
+
+func newTransfer(jobs int, verify bool) *transfer {
+	t := &transfer{
+		sem:   make(chan struct{}, max(jobs, 1)),
+		start: time.Now(),
+	}
+	if verify {
+		t.sums = map[string][]byte{}
+	}
+	return t
+}
+
+// Go runs fn once a job slot is available. If fn fails, the error is printed
+// with warn.
+func (t *transfer) Go(fn func() (int64, error)) {
+	t.sem <- struct{}{}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer func() { <-t.sem }()
+		n, err := fn()
+		if err != nil {
+			warn(err)
+			return
+		}
+		t.files.Add(1)
+		t.bytes.Add(n)
+	}()
+}
+
+// Queue is like Go, but never blocks. The copies are started in order by a
+// separate goroutine.
+func (t *transfer) Queue(fn func() (int64, error)) {
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+
+	t.queue = append(t.queue, fn)
+	if !t.queueRun {
+		t.queueRun = true
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			for {
+				t.queueMu.Lock()
+				if len(t.queue) == 0 {
+					t.queueRun = false
+					t.queueMu.Unlock()
+					return
+				}
+				fn := t.queue[0]
+				t.queue[0] = nil
+				t.queue = t.queue[1:]
+				t.queueMu.Unlock()
+
+				t.Go(fn)
+			}
+		}()
+	}
+}
+
+// Wait waits for all copies to finish, then prints a summary like adb.
+func (t *transfer) Wait(verb string) {
+	t.wg.Wait()
+	if !*jsonFlag {
+		d := time.Since(t.start)
+		fmt.Fprintf(os.Stderr, "%d files %s, %d bytes in %.3fs (%.1f MB/s)\n", t.files.Load(), verb, t.bytes.Load(), d.Seconds(), float64(t.bytes.Load())/1e6/max(d.Seconds(), 1e-3))
+	}
+}
+
+// hashReader wraps r to record the digest of the contents for the device file
+// name if verification is enabled. done must be called once r has been read
+// successfully.
+func (t *transfer) hashReader(name string, r io.Reader) (tr io.Reader, done func()) {
+	if t.sums == nil {
+		return r, func() {}
+	}
+	h := crypto.SHA256.New()
+	return io.TeeReader(r, h), func() {
+		t.sumsMu.Lock()
+		defer t.sumsMu.Unlock()
+		t.sums[name] = h.Sum(nil)
+	}
+}
+
+// Verify compares the digests of the copied files with the ones on the device.
+func (t *transfer) Verify(fsys *adbfs.FS) {
+	if t.sums == nil {
+		return
+	}
+	names := make([]string, 0, len(t.sums))
+	for name := range t.sums {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var ok int
+	for len(names) != 0 {
+		batch := names[:min(len(names), 512)]
+		names = names[len(batch):]
+
+		sums, errs := fsys.HashMany(batch, crypto.SHA256)
+		for i, name := range batch {
+			switch {
+			case errs[i] != nil:
+				warn(errs[i])
+			case !bytes.Equal(sums[i], t.sums[name]):
+				warn(fmt.Errorf("%s: checksum mismatch", devicePath(name)))
+			default:
+				ok++
+			}
+		}
+	}
+	if !*jsonFlag {
+		fmt.Fprintf(os.Stderr, "%d files verified\n", ok)
+	}
+}
+
+// transferEntry is the JSON output for a copied file.
+type transferEntry struct {
+	Path  string `json:"path"`
+	Local string `json:"local"`
+	Size  int64  `json:"size"`
+}
+
+// relPath returns the path of name relative to root.
+func relPath(root, name string) string {
+	switch {
+	case name == root:
+		return ""
+	case root == ".":
+		return name
+	default:
+		return name[len(root)+1:]
+	}
+}
+
+func pull(args []string) error {
+	flags := newFlagSet("pull")
+	preserve := flags.Bool("a", false, "preserve file modes and modification times")
+	jobs := flags.Int("j", runtime.NumCPU(), "copy up to `n` files at once")
+	verify := flags.Bool("verify", false, "compare SHA-256 checksums with the device after copying")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	remotes, local := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+
+	var localDir bool
+	if fi, err := os.Stat(local); err == nil {
+		localDir = fi.IsDir()
+	}
+	if len(remotes) > 1 && !localDir {
+		return fmt.Errorf("target %q is not a directory", local)
+	}
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	// directory attributes are set after the contents are written
+	type dirAttr struct {
+		path string
+		fi   fs.FileInfo
+	}
+	var dirs []dirAttr
+
+	t := newTransfer(*jobs, *verify)
+	for _, remote := range remotes {
+		root := resolve(remote)
+		dst := local
+		if localDir {
+			dst = filepath.Join(local, path.Base(devicePath(root)))
+		}
+		err := fsys.Walk(context.Background(), root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if name == root {
+					return err
+				}
+				warn(err)
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				warn(err)
+				return nil
+			}
+			lname := filepath.Join(dst, filepath.FromSlash(relPath(root, name)))
+			switch fi.Mode().Type() {
+			case 0:
+				// Walk holds a lock while calling fn, so it must not block on a job slot
+				t.Queue(func() (int64, error) {
+					n, err := pullFile(t, fsys, name, lname, fi, *preserve)
+					if err == nil && *jsonFlag {
+						err = printJSON(transferEntry{devicePath(name), lname, n})
+					}
+					return n, err
+				})
+			case fs.ModeDir:
+				if err := os.MkdirAll(lname, 0777); err != nil {
+					warn(err)
+					return fs.SkipDir
+				}
+				if *preserve {
+					dirs = append(dirs, dirAttr{lname, fi})
+				}
+			case fs.ModeSymlink:
+				target, err := fsys.Readlink(name)
+				if err != nil {
+					warn(err)
+					return nil
+				}
+				if err := os.Remove(lname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					warn(err)
+					return nil
+				}
+				if err := os.Symlink(target, lname); err != nil {
+					warn(err)
+				}
+			default:
+				warn(fmt.Errorf("skipping special file %s", devicePath(name)))
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			warn(err)
+		}
+	}
+	t.Wait("pulled")
+	t.Verify(fsys)
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].fi.Mode().Perm()); err != nil {
+			warn(err)
+		}
+		if err := os.Chtimes(dirs[i].path, time.Time{}, dirs[i].fi.ModTime()); err != nil {
+			warn(err)
+		}
+	}
+	return nil
+}
+
+func pullFile(t *transfer, fsys *adbfs.FS, name, lname string, fi fs.FileInfo, preserve bool) (int64, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	lf, err := os.OpenFile(lname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	r, done := t.hashReader(name, f)
+	n, err := io.Copy(lf, r)
+	if err1 := lf.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return n, err
+	}
+	done()
+	if preserve {
+		if err := os.Chmod(lname, fi.Mode().Perm()); err != nil {
+			return n, err
+		}
+		if err := os.Chtimes(lname, time.Time{}, fi.ModTime()); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// pushCheckSpace returns an error if the total size of the local files is
+// larger than the available space on the filesystem containing the nearest
+// existing parent of the remote path.
+func pushCheckSpace(fsys *adbfs.FS, locals []string, remote string) error {
+	var size int64
+	for _, local := range locals {
+		if err := filepath.WalkDir(local, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				fi, err := d.Info()
+				if err != nil {
+					return err
+				}
+				size += fi.Size()
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for {
+		st, err := fsys.StatFS(remote)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && remote != "." {
+				remote = path.Dir(remote)
+				continue
+			}
+			return err
+		}
+		if size > st.Available {
+			return fmt.Errorf("not enough space on %s: need %d bytes, but only %d are available", devicePath(remote), size, st.Available)
+		}
+		return nil
+	}
+}
+
+func push(args []string) error {
+	flags := newFlagSet("push")
+	jobs := flags.Int("j", runtime.NumCPU(), "copy up to `n` files at once")
+	verify := flags.Bool("verify", false, "compare SHA-256 checksums with the device after copying")
+	checkSpace := flags.Bool("checkspace", false, "check that there is enough free space on the device before copying")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	locals, remote := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+
+	fsys, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	remoteDir := strings.HasSuffix(remote, "/")
+	if fi, err := fsys.Stat(resolve(remote)); err == nil {
+		remoteDir = fi.IsDir()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(locals) > 1 && !remoteDir {
+		return fmt.Errorf("target %q is not a directory", remote)
+	}
+	if *checkSpace {
+		if err := pushCheckSpace(fsys, locals, resolve(remote)); err != nil {
+			return err
+		}
+	}
+
+	t := newTransfer(*jobs, *verify)
+	for _, local := range locals {
+		dst := resolve(remote)
+		if remoteDir {
+			dst = resolve(remote + "/" + filepath.Base(local))
+		}
+
+		// Send creates parent directories, so only empty ones need to be
+		// created explicitly
+		empty := map[string]string{}
+
+		err := filepath.WalkDir(local, func(lname string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if lname == local {
+					return err
+				}
+				warn(err)
+				return nil
+			}
+			rel, err := filepath.Rel(local, lname)
+			if err != nil {
+				return err
+			}
+			name := dst
+			if rel != "." {
+				name = path.Join(dst, filepath.ToSlash(rel))
+			}
+			delete(empty, filepath.Dir(lname))
+
+			fi, err := d.Info()
+			if err != nil {
+				warn(err)
+				return nil
+			}
+			switch fi.Mode().Type() {
+			case 0:
+				t.Go(func() (int64, error) {
+					f, err := os.Open(lname)
+					if err != nil {
+						return 0, err
+					}
+					defer f.Close()
+
+					r, done := t.hashReader(name, f)
+					if err := fsys.Send(name, r, fi.Mode().Perm(), fi.ModTime()); err != nil {
+						return 0, err
+					}
+					done()
+					if *jsonFlag {
+						if err := printJSON(transferEntry{devicePath(name), lname, fi.Size()}); err != nil {
+							return 0, err
+						}
+					}
+					return fi.Size(), nil
+				})
+			case fs.ModeDir:
+				empty[lname] = name
+			case fs.ModeSymlink:
+				target, err := os.Readlink(lname)
+				if err != nil {
+					warn(err)
+					return nil
+				}
+				if err := fsys.Send(name, strings.NewReader(target), fs.ModeSymlink, time.Time{}); err != nil {
+					warn(err)
+				}
+			default:
+				warn(fmt.Errorf("skipping special file %s", lname))
+			}
+			return nil
+		})
+		if err != nil {
+			warn(err)
+		}
+		for _, name := range empty {
+			if err := fsys.MkdirAll(name, 0777); err != nil {
+				warn(err)
+			}
+		}
+	}
+	t.Wait("pushed")
+	t.Verify(fsys)
+	return nil
+}
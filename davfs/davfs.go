@@ -0,0 +1,366 @@
+// Package davfs implements a WebDAV filesystem backed by the filesystem of an
+// ADB device.
+package davfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem implements webdav.FileSystem for a device.
+//
+// Files opened for writing are buffered in a local temporary file, and written
+// to the device when closed.
+type FileSystem struct {
+	fs *adbfs.FS
+}
+
+var (
+	_ webdav.FileSystem = (*FileSystem)(nil)
+	_ webdav.File       = (*file)(nil)
+	_ webdav.File       = (*dir)(nil)
+	_ webdav.File       = (*writeFile)(nil)
+)
+
+// New creates a new WebDAV filesystem for fsys.
+func New(fsys *adbfs.FS) *FileSystem {
+	return &FileSystem{fs: fsys}
+}
+
+// NewHandler creates a WebDAV handler for fsys with an in-memory lock system.
+func NewHandler(fsys *adbfs.FS) *webdav.Handler {
+	return &webdav.Handler{
+		FileSystem: New(fsys),
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+func (d *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name, err := resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return convertError(d.fs.Mkdir(name, perm))
+}
+
+func (d *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		fi, err := d.fs.Stat(name)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		if fi.IsDir() {
+			return &dir{fs: d.fs, name: name, fi: fi}, nil
+		}
+		f, err := d.fs.Open(name)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		return &file{File: f, name: name}, nil
+	}
+	return d.openWrite(name, flag, perm)
+}
+
+func (d *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name, err := resolve("removeall", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  fs.ErrPermission,
+		}
+	}
+	return convertError(d.fs.RemoveAll(name))
+}
+
+func (d *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, err := resolve("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = resolve("rename", newName)
+	if err != nil {
+		return err
+	}
+	return convertError(d.fs.Rename(oldName, newName))
+}
+
+func (d *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name, err := resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := d.fs.Stat(name)
+	return fi, convertError(err)
+}
+
+func (d *FileSystem) openWrite(name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var mode fs.FileMode
+	fi, err := d.fs.Stat(name)
+	switch {
+	case err == nil:
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  fs.ErrExist,
+			}
+		}
+		if fi.IsDir() {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  adbfs.ErrIsDirectory,
+			}
+		}
+		mode = fi.Mode().Perm()
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		mode = perm.Perm()
+	default:
+		return nil, convertError(err)
+	}
+
+	tmp, err := os.CreateTemp("", "adbfs-dav-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmp.Name()) // we only need the fd
+
+	if fi != nil && flag&os.O_TRUNC == 0 {
+		f, err := d.fs.Open(name)
+		if err == nil {
+			_, err = io.Copy(tmp, f)
+			f.Close()
+		}
+		if err == nil && flag&os.O_APPEND == 0 {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			tmp.Close()
+			return nil, convertError(err)
+		}
+	}
+	return &writeFile{File: tmp, fs: d.fs, name: name, mode: mode, dirty: fi == nil || flag&os.O_TRUNC != 0}, nil
+}
+
+// file is a file opened for reading.
+type file struct {
+	fs.File
+	name string
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func (f *file) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{
+		Op:   "readdir",
+		Path: f.name,
+		Err:  adbfs.ErrNotDirectory,
+	}
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: f.name,
+		Err:  fs.ErrPermission,
+	}
+}
+
+// dir is a directory opened for reading.
+type dir struct {
+	fs   *adbfs.FS
+	name string
+	fi   fs.FileInfo
+	de   []fs.FileInfo
+	read bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.fi, nil
+}
+
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.read {
+		de, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		for _, e := range de {
+			fi, err := e.Info()
+			if err != nil {
+				return nil, convertError(err)
+			}
+			d.de = append(d.de, fi)
+		}
+		d.read = true
+	}
+	if count <= 0 {
+		de := d.de
+		d.de = nil
+		return de, nil
+	}
+	if len(d.de) == 0 {
+		return nil, io.EOF
+	}
+	de := d.de[:min(count, len(d.de))]
+	d.de = d.de[len(de):]
+	return de, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: d.name,
+		Err:  adbfs.ErrIsDirectory,
+	}
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{
+		Op:   "seek",
+		Path: d.name,
+		Err:  adbfs.ErrIsDirectory,
+	}
+}
+
+func (d *dir) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: d.name,
+		Err:  adbfs.ErrIsDirectory,
+	}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+// writeFile is a file opened for writing, buffered in a temporary file. It is
+// only written back to the device if it was created, truncated, or modified,
+// since the webdav package also opens files for writing to set properties.
+type writeFile struct {
+	*os.File
+	fs    *adbfs.FS
+	name  string
+	mode  fs.FileMode
+	dirty bool
+}
+
+func (f *writeFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{
+		Op:   "readdir",
+		Path: f.name,
+		Err:  adbfs.ErrNotDirectory,
+	}
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &writeFileInfo{fi, f}, nil
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	f.dirty = true
+	return f.File.Write(p)
+}
+
+func (f *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	f.dirty = true
+	return f.File.WriteAt(p, off)
+}
+
+func (f *writeFile) WriteString(s string) (int, error) {
+	f.dirty = true
+	return f.File.WriteString(s)
+}
+
+func (f *writeFile) ReadFrom(r io.Reader) (int64, error) {
+	f.dirty = true
+	return f.File.ReadFrom(r)
+}
+
+func (f *writeFile) Truncate(size int64) error {
+	f.dirty = true
+	return f.File.Truncate(size)
+}
+
+func (f *writeFile) Close() error {
+	defer f.File.Close()
+	if !f.dirty {
+		return nil
+	}
+	if _, err := f.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return convertError(f.fs.Send(f.name, f.File, f.mode, time.Time{}))
+}
+
+type writeFileInfo struct {
+	fs.FileInfo
+	f *writeFile
+}
+
+func (fi *writeFileInfo) Name() string {
+	return path.Base(fi.f.name)
+}
+
+func (fi *writeFileInfo) Mode() fs.FileMode {
+	return fi.f.mode
+}
+
+// resolve converts a WebDAV path into a fs path.
+func resolve(op, name string) (string, error) {
+	if name = strings.TrimPrefix(path.Clean("/"+name), "/"); name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	return name, nil
+}
+
+// convertError converts errors to ones the webdav package understands, since
+// it uses os.IsNotExist and friends.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, target := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission} {
+		if errors.Is(err, target) {
+			switch e := err.(type) {
+			case *fs.PathError:
+				return &fs.PathError{Op: e.Op, Path: e.Path, Err: target}
+			case *os.LinkError:
+				return &os.LinkError{Op: e.Op, Old: e.Old, New: e.New, Err: target}
+			}
+			return target
+		}
+	}
+	return err
+}
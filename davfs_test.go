@@ -0,0 +1,97 @@
+package adbfs_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+	"github.com/pgaskin/go-adbfs/davfs"
+)
+
+func TestDavFS(t *testing.T) {
+	fsys, dev, dir := adbfs.NewTestFS(t, "")
+	srv := httptest.NewServer(davfs.NewHandler(fsys))
+	defer srv.Close()
+
+	var sends atomic.Int32
+	dev.SetHook(func(id, name string) {
+		if id == "SEND" {
+			sends.Add(1)
+		}
+	})
+
+	do := func(method, name string, body string, hdr ...string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+"/"+dir+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(buf)
+	}
+	expect := func(code int, body string) func(int, string) {
+		return func(c int, b string) {
+			t.Helper()
+			if c != code || !strings.Contains(b, body) {
+				t.Errorf("expected %d containing %q, got %d %q", code, body, c, b)
+			}
+		}
+	}
+
+	expect(http.StatusCreated, "")(do("MKCOL", "/sub", ""))
+	expect(http.StatusMethodNotAllowed, "")(do("MKCOL", "/sub", ""))
+	expect(http.StatusCreated, "")(do("PUT", "/a.txt", "hello"))
+	expect(http.StatusOK, "hello")(do("GET", "/a.txt", ""))
+	expect(http.StatusNotFound, "")(do("GET", "/missing", ""))
+
+	code, body := do("PROPFIND", "/", "", "Depth", "1")
+	expect(http.StatusMultiStatus, "/a.txt</")(code, body)
+	expect(http.StatusMultiStatus, "/sub/</")(code, body)
+	expect(http.StatusMultiStatus, "<D:getcontentlength>5</")(code, body)
+
+	// setting properties opens the file for writing, but it shouldn't be
+	// written back since it wasn't modified
+	mtime := time.Unix(1000000000, 0)
+	if err := os.Chtimes("/"+dir+"/a.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	n := sends.Load()
+	expect(http.StatusMultiStatus, "")(do("PROPPATCH", "/a.txt", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><x xmlns="urn:test">y</x></D:prop></D:set></D:propertyupdate>`))
+	if sends.Load() != n {
+		t.Errorf("unmodified file was written back")
+	}
+	if fi, err := fsys.Stat(dir + "/a.txt"); err != nil {
+		t.Errorf("stat: %v", err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Errorf("mtime changed to %s", fi.ModTime())
+	}
+
+	expect(http.StatusCreated, "")(do("MOVE", "/a.txt", "", "Destination", srv.URL+"/"+dir+"/sub/b.txt"))
+	expect(http.StatusNotFound, "")(do("GET", "/a.txt", ""))
+	expect(http.StatusOK, "hello")(do("GET", "/sub/b.txt", ""))
+	expect(http.StatusCreated, "")(do("PUT", "/sub/b.txt", "world"))
+	expect(http.StatusOK, "world")(do("GET", "/sub/b.txt", ""))
+
+	expect(http.StatusNoContent, "")(do("DELETE", "/sub", ""))
+	expect(http.StatusNotFound, "")(do("PROPFIND", "/sub", "", "Depth", "0"))
+	if _, err := os.Stat("/" + dir + "/sub"); !os.IsNotExist(err) {
+		t.Errorf("expected directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package adbfs
+
+import (
+	"context"
+	"net"
+)
+
+// Dial connects to a socket on the device through the ADB server, without
+// binding a port on the host. The socket spec is the same as for the remote
+// socket of Forward (e.g., tcp:8080 or localabstract:chrome_devtools_remote).
+func (c *FS) Dial(ctx context.Context, spec string) (net.Conn, error) {
+	if err := checkSocketSpec(spec, true); err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "adb", Addr: socketAddr(spec), Err: err}
+	}
+	conn, err := adbConnectDeviceContext(ctx, c.addr, c.serial, spec)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "adb", Addr: socketAddr(spec), Err: err}
+	}
+	return &deviceConn{Conn: conn, spec: spec}, nil
+}
+
+// DialContext is like Dial, but takes a network and address like
+// net.Dialer.DialContext, so it can be used for http.Transport. For tcp, tcp4,
+// and tcp6, the host is ignored if it is localhost or a loopback address.
+// Otherwise, the network is used as the kind of socket spec (e.g.,
+// localabstract).
+func (c *FS) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+		if ip := net.ParseIP(host); host == "" || host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return c.Dial(ctx, "tcp:"+port)
+		}
+		return c.Dial(ctx, "tcp:"+address)
+	}
+	return c.Dial(ctx, network+":"+address)
+}
+
+// deviceConn is a connection to a socket on the device.
+type deviceConn struct {
+	net.Conn
+	spec string
+}
+
+func (c *deviceConn) RemoteAddr() net.Addr {
+	return socketAddr(c.spec)
+}
+
+// socketAddr is the address of a socket on the device.
+type socketAddr string
+
+func (a socketAddr) Network() string {
+	return "adb"
+}
+
+func (a socketAddr) String() string {
+	return string(a)
+}
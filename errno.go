@@ -0,0 +1,174 @@
+package adbfs
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Errors for operations on files of the wrong type. They can also be matched
+// against an Error from the device using errors.Is.
+var (
+	ErrNotDirectory = errors.New("not a directory")
+	ErrIsDirectory  = errors.New("is a directory")
+)
+
+// Error is an error reported by the device.
+//
+// It can be compared against fs.ErrExist, fs.ErrNotExist, fs.ErrPermission,
+// ErrNotDirectory, and ErrIsDirectory using errors.Is. If the errno is known,
+// it also unwraps to the equivalent syscall.Errno on the host (e.g.,
+// errors.Is(err, syscall.ENOSPC)), where available.
+type Error struct {
+	Msg   string // raw message from the device, or the errno description
+	Errno uint32 // linux errno value, or zero if unknown
+}
+
+// syncError creates a new Error from a message returned by the device,
+// attempting to parse the errno from it.
+func syncError(msg string) *Error {
+	return &Error{
+		Msg:   msg,
+		Errno: errnoParse(msg),
+	}
+}
+
+// syncErrno creates a new Error from an errno returned by the device.
+func syncErrno(errno uint32) *Error {
+	msg := "errno " + strconv.FormatUint(uint64(errno), 10)
+	if e, ok := errnoTable[errno]; ok {
+		msg = e.msg[0]
+	}
+	return &Error{
+		Msg:   msg,
+		Errno: errno,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case fs.ErrExist:
+		return e.Errno == errno_EEXIST || e.Errno == errno_ENOTEMPTY
+	case fs.ErrNotExist:
+		return e.Errno == errno_ENOENT
+	case fs.ErrPermission:
+		return e.Errno == errno_EACCES || e.Errno == errno_EPERM
+	case ErrNotDirectory:
+		return e.Errno == errno_ENOTDIR
+	case ErrIsDirectory:
+		return e.Errno == errno_EISDIR
+	}
+	return false
+}
+
+func (e *Error) Unwrap() error {
+	if e.Errno == 0 {
+		return nil
+	}
+	return errnoHost(e.Errno)
+}
+
+// errnoParse attempts to find the errno for a message ending with a libc error
+// string (e.g., "open failed: No such file or directory").
+func errnoParse(msg string) uint32 {
+	for errno, e := range errnoTable {
+		for _, s := range e.msg {
+			if msg == s || strings.HasSuffix(msg, ": "+s) {
+				return errno
+			}
+		}
+	}
+	return 0
+}
+
+// linux errno values
+const (
+	errno_EPERM        uint32 = 1
+	errno_ENOENT       uint32 = 2
+	errno_ESRCH        uint32 = 3
+	errno_EINTR        uint32 = 4
+	errno_EIO          uint32 = 5
+	errno_ENXIO        uint32 = 6
+	errno_E2BIG        uint32 = 7
+	errno_ENOEXEC      uint32 = 8
+	errno_EBADF        uint32 = 9
+	errno_ECHILD       uint32 = 10
+	errno_EAGAIN       uint32 = 11
+	errno_ENOMEM       uint32 = 12
+	errno_EACCES       uint32 = 13
+	errno_EFAULT       uint32 = 14
+	errno_EBUSY        uint32 = 16
+	errno_EEXIST       uint32 = 17
+	errno_EXDEV        uint32 = 18
+	errno_ENODEV       uint32 = 19
+	errno_ENOTDIR      uint32 = 20
+	errno_EISDIR       uint32 = 21
+	errno_EINVAL       uint32 = 22
+	errno_ENFILE       uint32 = 23
+	errno_EMFILE       uint32 = 24
+	errno_ENOTTY       uint32 = 25
+	errno_ETXTBSY      uint32 = 26
+	errno_EFBIG        uint32 = 27
+	errno_ENOSPC       uint32 = 28
+	errno_ESPIPE       uint32 = 29
+	errno_EROFS        uint32 = 30
+	errno_EMLINK       uint32 = 31
+	errno_EPIPE        uint32 = 32
+	errno_ENAMETOOLONG uint32 = 36
+	errno_ENOSYS       uint32 = 38
+	errno_ENOTEMPTY    uint32 = 39
+	errno_ELOOP        uint32 = 40
+	errno_EOPNOTSUPP   uint32 = 95
+	errno_ESTALE       uint32 = 116
+	errno_EDQUOT       uint32 = 122
+)
+
+// errnoTable contains the libc error strings for errno values, with the bionic
+// one first, followed by the glibc one if it differs.
+var errnoTable = map[uint32]struct {
+	msg []string
+}{
+	errno_EPERM:        {[]string{"Operation not permitted"}},
+	errno_ENOENT:       {[]string{"No such file or directory"}},
+	errno_ESRCH:        {[]string{"No such process"}},
+	errno_EINTR:        {[]string{"Interrupted system call"}},
+	errno_EIO:          {[]string{"I/O error", "Input/output error"}},
+	errno_ENXIO:        {[]string{"No such device or address"}},
+	errno_E2BIG:        {[]string{"Argument list too long"}},
+	errno_ENOEXEC:      {[]string{"Exec format error"}},
+	errno_EBADF:        {[]string{"Bad file descriptor"}},
+	errno_ECHILD:       {[]string{"No child processes"}},
+	errno_EAGAIN:       {[]string{"Try again", "Resource temporarily unavailable"}},
+	errno_ENOMEM:       {[]string{"Out of memory", "Cannot allocate memory"}},
+	errno_EACCES:       {[]string{"Permission denied"}},
+	errno_EFAULT:       {[]string{"Bad address"}},
+	errno_EBUSY:        {[]string{"Device or resource busy"}},
+	errno_EEXIST:       {[]string{"File exists"}},
+	errno_EXDEV:        {[]string{"Cross-device link", "Invalid cross-device link"}},
+	errno_ENODEV:       {[]string{"No such device"}},
+	errno_ENOTDIR:      {[]string{"Not a directory"}},
+	errno_EISDIR:       {[]string{"Is a directory"}},
+	errno_EINVAL:       {[]string{"Invalid argument"}},
+	errno_ENFILE:       {[]string{"File table overflow", "Too many open files in system"}},
+	errno_EMFILE:       {[]string{"Too many open files"}},
+	errno_ENOTTY:       {[]string{"Not a typewriter", "Inappropriate ioctl for device"}},
+	errno_ETXTBSY:      {[]string{"Text file busy"}},
+	errno_EFBIG:        {[]string{"File too large"}},
+	errno_ENOSPC:       {[]string{"No space left on device"}},
+	errno_ESPIPE:       {[]string{"Illegal seek"}},
+	errno_EROFS:        {[]string{"Read-only file system"}},
+	errno_EMLINK:       {[]string{"Too many links"}},
+	errno_EPIPE:        {[]string{"Broken pipe"}},
+	errno_ENAMETOOLONG: {[]string{"File name too long"}},
+	errno_ENOSYS:       {[]string{"Function not implemented"}},
+	errno_ENOTEMPTY:    {[]string{"Directory not empty"}},
+	errno_ELOOP:        {[]string{"Too many symbolic links encountered", "Too many levels of symbolic links"}},
+	errno_EOPNOTSUPP:   {[]string{"Operation not supported on transport endpoint", "Operation not supported"}},
+	errno_ESTALE:       {[]string{"Stale NFS file handle", "Stale file handle"}},
+	errno_EDQUOT:       {[]string{"Quota exceeded", "Disk quota exceeded"}},
+}
@@ -0,0 +1,9 @@
+//go:build plan9
+
+package adbfs
+
+// errnoHost converts a linux errno value to the equivalent syscall.Errno on the
+// host, if any.
+func errnoHost(errno uint32) error {
+	return nil // plan9 doesn't have errno values
+}
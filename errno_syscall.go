@@ -0,0 +1,87 @@
+//go:build !plan9
+
+package adbfs
+
+import "syscall"
+
+// errnoHost converts a linux errno value to the equivalent syscall.Errno on the
+// host, if any. Only errno values defined on all platforms are included.
+func errnoHost(errno uint32) error {
+	switch errno {
+	case errno_EPERM:
+		return syscall.EPERM
+	case errno_ENOENT:
+		return syscall.ENOENT
+	case errno_ESRCH:
+		return syscall.ESRCH
+	case errno_EINTR:
+		return syscall.EINTR
+	case errno_EIO:
+		return syscall.EIO
+	case errno_ENXIO:
+		return syscall.ENXIO
+	case errno_E2BIG:
+		return syscall.E2BIG
+	case errno_ENOEXEC:
+		return syscall.ENOEXEC
+	case errno_EBADF:
+		return syscall.EBADF
+	case errno_ECHILD:
+		return syscall.ECHILD
+	case errno_EAGAIN:
+		return syscall.EAGAIN
+	case errno_ENOMEM:
+		return syscall.ENOMEM
+	case errno_EACCES:
+		return syscall.EACCES
+	case errno_EFAULT:
+		return syscall.EFAULT
+	case errno_EBUSY:
+		return syscall.EBUSY
+	case errno_EEXIST:
+		return syscall.EEXIST
+	case errno_EXDEV:
+		return syscall.EXDEV
+	case errno_ENODEV:
+		return syscall.ENODEV
+	case errno_ENOTDIR:
+		return syscall.ENOTDIR
+	case errno_EISDIR:
+		return syscall.EISDIR
+	case errno_EINVAL:
+		return syscall.EINVAL
+	case errno_ENFILE:
+		return syscall.ENFILE
+	case errno_EMFILE:
+		return syscall.EMFILE
+	case errno_ENOTTY:
+		return syscall.ENOTTY
+	case errno_EFBIG:
+		return syscall.EFBIG
+	case errno_ENOSPC:
+		return syscall.ENOSPC
+	case errno_ESPIPE:
+		return syscall.ESPIPE
+	case errno_EROFS:
+		return syscall.EROFS
+	case errno_EMLINK:
+		return syscall.EMLINK
+	case errno_EPIPE:
+		return syscall.EPIPE
+	case errno_ENAMETOOLONG:
+		return syscall.ENAMETOOLONG
+	case errno_ENOSYS:
+		return syscall.ENOSYS
+	case errno_ENOTEMPTY:
+		return syscall.ENOTEMPTY
+	case errno_ELOOP:
+		return syscall.ELOOP
+	case errno_EOPNOTSUPP:
+		return syscall.EOPNOTSUPP
+	case errno_ESTALE:
+		return syscall.ESTALE
+	case errno_EDQUOT:
+		return syscall.EDQUOT
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package adbfs
+
+import "testing"
+
+// These allow tests for the server packages, which are in the adbfs_test
+// package, to use the fake device.
+
+type FakeDevice = fakeDevice
+
+func NewTestFS(t testing.TB, features string) (*FS, *FakeDevice, string) {
+	return newTestFS(t, features)
+}
+
+func (d *fakeDevice) SetHook(fn func(id, name string)) {
+	d.setHook(fn)
+}
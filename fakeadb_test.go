@@ -0,0 +1,485 @@
+package adbfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDevice is an ADB server with a single device, where the device is the
+// host. The device root is the host root, and shell commands are run using the
+// host's sh, so the tests using it must only touch their own temporary
+// directory.
+type fakeDevice struct {
+	features string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	l      net.Listener
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	hook   func(id, name string) // called before each sync request, if set
+}
+
+const fakeSerial = "fake"
+
+// fakeUID and fakeGID are the owner of all files on the fake device.
+const (
+	fakeUID = 1000 // system
+	fakeGID = 1015 // sdcard_rw
+)
+
+// newTestFS starts a fake device with the specified features (or the default
+// ones if empty), and connects to it. It returns the FS and the name of an
+// empty temporary directory on the device.
+func newTestFS(t testing.TB, features string) (*FS, *fakeDevice, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("fake device requires a unix host")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("fake device requires sh")
+	}
+	if features == "" {
+		features = "shell_v2,cmd,stat_v2,ls_v2"
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	d := &fakeDevice{
+		features: features,
+		l:        l,
+		conns:    map[net.Conn]struct{}{},
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.wg.Add(1)
+	go d.serve()
+	t.Cleanup(d.close)
+
+	fsys, err := Connect(l.Addr().String(), fakeSerial)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { fsys.Close() })
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("resolve temp dir: %v", err)
+	}
+	return fsys, d, strings.TrimPrefix(filepath.ToSlash(dir), "/")
+}
+
+// setHook sets a function to be called before each sync request is handled.
+func (d *fakeDevice) setHook(fn func(id, name string)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.hook = fn
+}
+
+func (d *fakeDevice) close() {
+	d.cancel()
+	d.l.Close()
+	d.mu.Lock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *fakeDevice) serve() {
+	defer d.wg.Done()
+	for {
+		conn, err := d.l.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.conns[conn] = struct{}{}
+		d.mu.Unlock()
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer func() {
+				d.mu.Lock()
+				delete(d.conns, conn)
+				d.mu.Unlock()
+			}()
+			defer conn.Close()
+			d.handle(conn)
+		}()
+	}
+}
+
+func (d *fakeDevice) handle(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		var n [4]byte
+		if _, err := io.ReadFull(br, n[:]); err != nil {
+			return
+		}
+		sz, err := strconv.ParseUint(string(n[:]), 16, 16)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, sz)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return
+		}
+		switch svc := string(buf); {
+		case svc == "host:get-serialno":
+			fmt.Fprintf(conn, "OKAY%04x%s", len(fakeSerial), fakeSerial)
+			return
+		case svc == "host-serial:"+fakeSerial+":features":
+			fmt.Fprintf(conn, "OKAY%04x%s", len(d.features), d.features)
+			return
+		case svc == "host:transport:"+fakeSerial, svc == "host:transport-any":
+			io.WriteString(conn, "OKAY")
+		case svc == "sync:":
+			io.WriteString(conn, "OKAY")
+			d.sync(br, conn)
+			return
+		case strings.HasPrefix(svc, "shell,v2,raw:"):
+			io.WriteString(conn, "OKAY")
+			d.shell(br, conn, strings.TrimPrefix(svc, "shell,v2,raw:"))
+			return
+		case strings.HasPrefix(svc, "exec:"):
+			io.WriteString(conn, "OKAY")
+			d.exec(br, conn, strings.TrimPrefix(svc, "exec:"))
+			return
+		default:
+			msg := "unknown service " + svc
+			fmt.Fprintf(conn, "FAIL%04x%s", len(msg), msg)
+			return
+		}
+	}
+}
+
+// exec implements the exec service, which only has stdout.
+func (d *fakeDevice) exec(br *bufio.Reader, conn net.Conn, cmd string) {
+	p := exec.CommandContext(d.ctx, "sh", "-c", cmd)
+	p.Stdout = conn
+	stdin, err := p.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err := p.Start(); err != nil {
+		return
+	}
+	go func() {
+		// this will be stopped when the conn is closed after the command exits
+		io.Copy(stdin, br)
+		stdin.Close()
+	}()
+	p.Wait()
+}
+
+// shell implements the shell v2 protocol.
+func (d *fakeDevice) shell(br *bufio.Reader, conn net.Conn, cmd string) {
+	var (
+		mu     sync.Mutex
+		stdout = shellWriter{&mu, conn, shellID_Stdout}
+		stderr = shellWriter{&mu, conn, shellID_Stderr}
+	)
+	stdin, stdinW := io.Pipe()
+	go func() {
+		for {
+			id, data, err := shellRecv(br)
+			if err != nil {
+				stdinW.CloseWithError(err)
+				return
+			}
+			switch id {
+			case shellID_Stdin:
+				stdinW.Write(data)
+			case shellID_CloseStdin:
+				stdinW.Close()
+			}
+		}
+	}()
+	p := exec.CommandContext(d.ctx, "sh", "-c", cmd)
+	p.Stdin, p.Stdout, p.Stderr = stdin, stdout, stderr
+	p.WaitDelay = 100 * time.Millisecond
+
+	code := 0
+	if err := p.Run(); err != nil {
+		if xe, ok := err.(*exec.ExitError); ok && xe.ExitCode() >= 0 {
+			code = xe.ExitCode()
+		} else {
+			code = 127
+		}
+	}
+	stdout.send(shellID_Exit, []byte{byte(code)})
+}
+
+type shellWriter struct {
+	mu   *sync.Mutex
+	conn net.Conn
+	id   shellID
+}
+
+func (w shellWriter) Write(p []byte) (int, error) {
+	if err := w.send(w.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w shellWriter) send(id shellID, p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return shellSend(w.conn, id, p)
+}
+
+// sync implements the sync service.
+func (d *fakeDevice) sync(br *bufio.Reader, conn net.Conn) {
+	bw := bufio.NewWriter(conn)
+	defer bw.Flush()
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return
+		}
+		id := syncID(hdr[:4])
+		buf := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return
+		}
+		name := string(buf)
+		if id == syncID_QUIT {
+			return
+		}
+		if id == syncID_SEND_V1 {
+			if i := strings.LastIndexByte(name, ','); i != -1 {
+				name = name[:i]
+			}
+		}
+		d.mu.Lock()
+		hook := d.hook
+		d.mu.Unlock()
+		if hook != nil {
+			bw.Flush()
+			hook(id.String(), name)
+		}
+		switch id {
+		case syncID_LSTAT_V1:
+			var st sync_stat_v1
+			if fi, err := os.Lstat(name); err == nil {
+				v2 := fakeStat(name, fi)
+				st = sync_stat_v1{Mode: v2.Mode, Size: uint32(v2.Size), Mtime: uint32(v2.Mtime)}
+			}
+			fakeObject(bw, id, st)
+		case syncID_LSTAT_V2, syncID_STAT_V2:
+			stat := os.Lstat
+			if id == syncID_STAT_V2 {
+				stat = os.Stat
+			}
+			var st sync_stat_v2
+			if fi, err := stat(name); err != nil {
+				st.Error = fakeErrno(err)
+			} else {
+				st = *fakeStat(name, fi)
+			}
+			fakeObject(bw, id, st)
+		case syncID_LIST_V1, syncID_LIST_V2:
+			d.list(bw, id, name)
+		case syncID_RECV_V1:
+			d.recv(bw, name)
+		case syncID_SEND_V1:
+			if !d.send(br, bw, string(buf)) {
+				return
+			}
+		default:
+			fakeFail(bw, "unknown command "+id.String())
+			return
+		}
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *fakeDevice) list(bw *bufio.Writer, id syncID, name string) {
+	names := []string{".", ".."}
+	if de, err := os.ReadDir(name); err == nil {
+		for _, e := range de {
+			names = append(names, e.Name())
+		}
+	} else {
+		names = nil
+	}
+	for _, n := range names {
+		fi, err := os.Lstat(filepath.Join(name, n))
+		if id == syncID_LIST_V1 {
+			if err != nil {
+				continue
+			}
+			st := fakeStat(filepath.Join(name, n), fi)
+			fakeObject(bw, syncID_DENT_V1, sync_dent_v1{Mode: st.Mode, Size: uint32(st.Size), Mtime: uint32(st.Mtime), Namelen: uint32(len(n))})
+		} else {
+			var st sync_stat_v2
+			if err != nil {
+				st.Error = fakeErrno(err)
+			} else {
+				st = *fakeStat(filepath.Join(name, n), fi)
+			}
+			fakeObject(bw, syncID_DENT_V2, sync_dent_v2{st.Error, st.Dev, st.Ino, st.Mode, st.Nlink, st.Uid, st.Gid, st.Size, st.Atime, st.Mtime, st.Ctime, uint32(len(n))})
+		}
+		bw.WriteString(n)
+	}
+	if id == syncID_LIST_V1 {
+		fakeObject(bw, syncID_DONE, sync_dent_v1{})
+	} else {
+		fakeObject(bw, syncID_DONE, sync_dent_v2{})
+	}
+}
+
+func (d *fakeDevice) recv(bw *bufio.Writer, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		fakeFail(bw, "open failed: "+syncErrno(fakeErrno(err)).Msg)
+		return
+	}
+	defer f.Close()
+
+	buf := make([]byte, syncDataMax)
+	for {
+		n, err := f.Read(buf)
+		if n != 0 {
+			fakeObject(bw, syncID_DATA, sync_data{uint32(n)})
+			bw.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fakeFail(bw, "read failed: "+syncErrno(fakeErrno(err)).Msg)
+			return
+		}
+	}
+	fakeObject(bw, syncID_DONE, sync_status{})
+}
+
+func (d *fakeDevice) send(br *bufio.Reader, bw *bufio.Writer, req string) bool {
+	i := strings.LastIndexByte(req, ',')
+	if i == -1 {
+		fakeFail(bw, "missing mode")
+		return false
+	}
+	name := req[:i]
+	mode, err := strconv.ParseUint(req[i+1:], 10, 32)
+	if err != nil {
+		fakeFail(bw, "invalid mode")
+		return false
+	}
+
+	var (
+		data  []byte
+		mtime uint32
+	)
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return false
+		}
+		n := binary.LittleEndian.Uint32(hdr[4:])
+		if syncID(hdr[:4]) == syncID_DONE {
+			mtime = n
+			break
+		}
+		if syncID(hdr[:4]) != syncID_DATA {
+			fakeFail(bw, "unexpected "+syncID(hdr[:4]).String())
+			return false
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return false
+		}
+		data = append(data, buf...)
+	}
+
+	os.MkdirAll(filepath.Dir(name), 0777)
+	if syncMode(uint32(mode)).Type() == fs.ModeSymlink {
+		os.Remove(name)
+		err = os.Symlink(string(data), name)
+	} else {
+		os.Remove(name) // like adbd, which creates a new file
+		err = os.WriteFile(name, data, syncMode(uint32(mode)).Perm())
+		if err == nil {
+			os.Chmod(name, syncMode(uint32(mode)).Perm()) // ignore the umask
+			os.Chtimes(name, time.Unix(int64(mtime), 0), time.Unix(int64(mtime), 0))
+		}
+	}
+	if err != nil {
+		fakeFail(bw, "couldn't create file: "+syncErrno(fakeErrno(err)).Msg)
+		return true
+	}
+	fakeObject(bw, syncID_OKAY, sync_status{})
+	return true
+}
+
+func fakeObject(w io.Writer, id syncID, obj any) {
+	w.Write(id[:])
+	binary.Write(w, binary.LittleEndian, obj)
+}
+
+func fakeFail(w io.Writer, msg string) {
+	fakeObject(w, syncID_FAIL, sync_status{uint32(len(msg))})
+	io.WriteString(w, msg)
+}
+
+// fakeStat converts fi. Since the inode number isn't portably available, a
+// hash of the name is used instead.
+func fakeStat(name string, fi fs.FileInfo) *sync_stat_v2 {
+	h := fnv.New64a()
+	io.WriteString(h, filepath.Clean(name))
+	return &sync_stat_v2{
+		Dev:   1,
+		Ino:   h.Sum64(),
+		Mode:  syncModeFrom(fi.Mode()),
+		Nlink: 1,
+		Uid:   fakeUID,
+		Gid:   fakeGID,
+		Size:  uint64(fi.Size()),
+		Atime: fi.ModTime().Unix(),
+		Mtime: fi.ModTime().Unix(),
+		Ctime: fi.ModTime().Unix(),
+	}
+}
+
+// fakeErrno converts a host error into a linux errno.
+func fakeErrno(err error) uint32 {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	for errno, e := range errnoTable {
+		for _, s := range e.msg {
+			if strings.EqualFold(s, err.Error()) {
+				return errno
+			}
+		}
+	}
+	return errno_EIO
+}
@@ -0,0 +1,177 @@
+package adbfs
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PortForward is a port forwarding rule.
+type PortForward struct {
+	Local  string // socket spec on the host
+	Remote string // socket spec on the device
+}
+
+// Forward forwards connections to the local socket on the host to the remote
+// socket on the device, replacing any existing rule for local. If local is
+// tcp:0, a port is allocated. The local socket spec is returned.
+//
+// The local socket may be tcp:<port>, localabstract:<name>,
+// localreserved:<name>, or localfilesystem:<path>. The remote socket may also
+// be jdwp:<pid>.
+func (c *FS) Forward(local, remote string) (string, error) {
+	if err := checkSocketSpec(local, false); err != nil {
+		return "", fmt.Errorf("forward: local: %w", err)
+	}
+	if err := checkSocketSpec(remote, true); err != nil {
+		return "", fmt.Errorf("forward: remote: %w", err)
+	}
+	svc := "host-serial:" + c.serial + ":forward:" + local + ";" + remote
+	conn, err := adbConnect(c.addr, svc)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return forwardResult(conn, svc, local)
+}
+
+// ListForwards returns the port forwarding rules for the device.
+func (c *FS) ListForwards() ([]PortForward, error) {
+	buf, err := adbConnectSingle(c.addr, "host-serial:"+c.serial+":list-forward")
+	if err != nil {
+		return nil, err
+	}
+	var fwd []PortForward
+	for _, line := range strings.Split(string(buf), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] == c.serial {
+			fwd = append(fwd, PortForward{Local: f[1], Remote: f[2]})
+		}
+	}
+	return fwd, nil
+}
+
+// RemoveForward removes the port forwarding rule for the local socket.
+func (c *FS) RemoveForward(local string) error {
+	return c.killForward("host-serial:" + c.serial + ":killforward:" + local)
+}
+
+// RemoveAllForwards removes all port forwarding rules for the device.
+func (c *FS) RemoveAllForwards() error {
+	return c.killForward("host-serial:" + c.serial + ":killforward-all")
+}
+
+func (c *FS) killForward(svc string) error {
+	conn, err := adbConnect(c.addr, svc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := adbRecvOkay(conn); err != nil {
+		return fmt.Errorf("service %q: %w", svc, err)
+	}
+	return nil
+}
+
+// ReverseForward forwards connections to the remote socket on the device to
+// the local socket on the host, replacing any existing rule for remote. If
+// remote is tcp:0, a port is allocated. The remote socket spec is returned.
+//
+// The socket specs are the same as for Forward, but jdwp is not supported.
+func (c *FS) ReverseForward(remote, local string) (string, error) {
+	if err := checkSocketSpec(remote, false); err != nil {
+		return "", fmt.Errorf("reverse forward: remote: %w", err)
+	}
+	if err := checkSocketSpec(local, false); err != nil {
+		return "", fmt.Errorf("reverse forward: local: %w", err)
+	}
+	svc := "reverse:forward:" + remote + ";" + local
+	conn, err := adbConnectDevice(c.addr, c.serial, svc)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return forwardResult(conn, svc, remote)
+}
+
+// ListReverseForwards returns the reverse port forwarding rules for the
+// device.
+func (c *FS) ListReverseForwards() ([]PortForward, error) {
+	svc := "reverse:list-forward"
+	conn, err := adbConnectDevice(c.addr, c.serial, svc)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf, err := adbRecvMsg(conn)
+	if err != nil {
+		return nil, fmt.Errorf("service %q: recv message: %w", svc, err)
+	}
+	var fwd []PortForward
+	for _, line := range strings.Split(string(buf), "\n") {
+		if f := strings.Fields(line); len(f) == 3 {
+			fwd = append(fwd, PortForward{Local: f[2], Remote: f[1]})
+		}
+	}
+	return fwd, nil
+}
+
+// RemoveReverseForward removes the reverse port forwarding rule for the remote
+// socket.
+func (c *FS) RemoveReverseForward(remote string) error {
+	return c.killReverseForward("reverse:killforward:" + remote)
+}
+
+// RemoveAllReverseForwards removes all reverse port forwarding rules for the
+// device.
+func (c *FS) RemoveAllReverseForwards() error {
+	return c.killReverseForward("reverse:killforward-all")
+}
+
+func (c *FS) killReverseForward(svc string) error {
+	conn, err := adbConnectDevice(c.addr, c.serial, svc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := adbRecvOkay(conn); err != nil {
+		return fmt.Errorf("service %q: %w", svc, err)
+	}
+	return nil
+}
+
+// forwardResult reads the result of a forward service, returning spec with the
+// allocated port if it was tcp:0.
+func forwardResult(conn net.Conn, svc, spec string) (string, error) {
+	if err := adbRecvOkay(conn); err != nil {
+		return "", fmt.Errorf("service %q: %w", svc, err)
+	}
+	if spec == "tcp:0" {
+		buf, err := adbRecvMsg(conn)
+		if err != nil {
+			return "", fmt.Errorf("service %q: recv message: %w", svc, err)
+		}
+		return "tcp:" + strings.TrimSpace(string(buf)), nil
+	}
+	return spec, nil
+}
+
+// checkSocketSpec checks that spec is a supported socket spec. If device is
+// true, jdwp is allowed.
+func checkSocketSpec(spec string, device bool) error {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return fmt.Errorf("invalid socket spec %q", spec)
+	}
+	switch kind {
+	case "tcp", "localabstract", "localreserved", "localfilesystem":
+		return nil
+	case "jdwp":
+		if device {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported socket spec %q", spec)
+}
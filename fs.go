@@ -9,8 +9,10 @@ import (
 	"net"
 	"path"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,11 +27,6 @@ import (
 // https://github.com/cstyan/adbDocumentation
 // note: sync STA2/LST2 since 2016, LIS2 since 2019
 
-var (
-	errNotDirectory = errors.New("not a directory")
-	errIsDirectory  = errors.New("is a directory")
-)
-
 // FS provides access to the filesystem of an ADB device.
 //
 // A pool of connections is used. Additional connections will be opened for
@@ -41,11 +38,18 @@ var (
 // large files), it should be read fully and closed as soon as possible to
 // prevent additional connections from being opened unnecessarily.
 type FS struct {
-	addr   string
-	serial string
-	feat   []string
-	connMu sync.Mutex
-	conn   map[net.Conn]bool // [conn]used
+	addr    string
+	serial  string
+	featMu  sync.Mutex
+	feat    []string
+	propMu  sync.Mutex
+	prop    map[string]string // cached ro.* properties
+	connMu  sync.Mutex
+	conn    map[net.Conn]bool // [conn]free
+	connGen atomic.Uint64     // incremented by reset
+
+	cacheMu sync.Mutex
+	cache   map[*Cache]struct{} // open caches, which reference the FS until closed
 }
 
 var (
@@ -92,6 +96,26 @@ func Connect(addr, serial string) (*FS, error) {
 	return c, nil
 }
 
+func (c *FS) hasFeature(name string) bool {
+	c.featMu.Lock()
+	defer c.featMu.Unlock()
+
+	return slices.Contains(c.feat, name)
+}
+
+// fsFeat contains the optional sync features used by the fs functions.
+type fsFeat struct {
+	statV2 bool
+	lsV2   bool
+}
+
+func (c *FS) fsFeat() fsFeat {
+	return fsFeat{
+		statV2: c.hasFeature(syncFeature_stat_v2),
+		lsV2:   c.hasFeature(syncFeature_ls_v2),
+	}
+}
+
 func (c *FS) getConn() (net.Conn, error) {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
@@ -107,10 +131,11 @@ func (c *FS) getConn() (net.Conn, error) {
 		}
 	}
 
-	conn, err := adbConnectDevice(c.addr, c.serial, "sync:")
+	raw, err := adbConnectDevice(c.addr, c.serial, "sync:")
 	if err != nil {
 		return nil, fmt.Errorf("connect to sync service: %w", err)
 	}
+	conn := &poolConn{Conn: raw, c: c, gen: c.connGen.Load()}
 	c.conn[conn] = false
 
 	return conn, nil
@@ -120,8 +145,11 @@ func (c *FS) putConn(conn net.Conn) {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 
-	if _, ok := c.conn[conn]; ok {
+	if _, ok := c.conn[conn]; ok && !conn.(*poolConn).stale() {
 		c.conn[conn] = true
+	} else {
+		conn.Close() // removed by reset or Close
+		delete(c.conn, conn)
 	}
 }
 
@@ -133,6 +161,66 @@ func (c *FS) delConn(conn net.Conn) {
 	delete(c.conn, conn)
 }
 
+// ErrReset is returned by operations which were using a connection when adbd
+// was restarted by Root or Unroot.
+var ErrReset = errors.New("connection reset by adbd restart")
+
+// poolConn is a connection from the pool.
+type poolConn struct {
+	net.Conn
+	c   *FS
+	gen uint64
+}
+
+// stale returns true if the pool has been reset since conn was opened.
+func (conn *poolConn) stale() bool {
+	return conn.gen != conn.c.connGen.Load()
+}
+
+func (conn *poolConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	if err != nil && conn.stale() {
+		err = ErrReset
+	}
+	return n, err
+}
+
+func (conn *poolConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	if err != nil && conn.stale() {
+		err = ErrReset
+	}
+	return n, err
+}
+
+func (c *FS) addCache(cache *Cache) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	if c.cache == nil {
+		c.cache = make(map[*Cache]struct{})
+	}
+	c.cache[cache] = struct{}{}
+}
+
+func (c *FS) delCache(cache *Cache) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	delete(c.cache, cache)
+}
+
+// invalidate must be called after name is modified. Every open cache is
+// scanned, so the cost is proportional to the total number of cached entries.
+func (c *FS) invalidate(name string) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	for cache := range c.cache {
+		cache.Invalidate(name)
+	}
+}
+
 func (c *FS) Close() error {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
@@ -167,28 +255,11 @@ func (c *FS) Open(name string) (fs.File, error) {
 		}
 	}()
 
-	if err := syncRequest(conn, syncID_LSTAT_V1, "/"+name); err != nil {
-		return nil, &fs.PathError{
-			Op:   "stat",
-			Path: name,
-			Err:  err,
-		}
-	}
-	st, err := syncResponseObject[sync_stat_v1](conn, syncID_LSTAT_V1)
+	fi, err := fsStat(conn, name, c.fsFeat())
 	if err != nil {
-		return nil, &fs.PathError{
-			Op:   "stat",
-			Path: name,
-			Err:  err,
-		}
-	}
-	if *st == (sync_stat_v1{}) {
-		return nil, &fs.PathError{
-			Op:   "stat",
-			Path: name,
-			Err:  fmt.Errorf("%w (or permission denied)", fs.ErrNotExist), // we have no way to tell from here with v1
-		}
+		return nil, err
 	}
+	st := fi.(*fsFileInfo).st
 
 	f := &fsFile{c: c, name: name, st: st}
 	if !syncMode(st.Mode).IsDir() {
@@ -220,17 +291,50 @@ func (c *FS) Stat(name string) (fs.FileInfo, error) {
 	}
 	defer c.putConn(conn)
 
-	return fsStat(conn, name)
+	return fsStat(conn, name, c.fsFeat())
 }
 
-func fsStat(conn net.Conn, name string) (fs.FileInfo, error) {
-	if err := syncRequest(conn, syncID_LSTAT_V1, "/"+name); err != nil {
-		return nil, &fs.PathError{
+func fsStat(conn net.Conn, name string, feat fsFeat) (fs.FileInfo, error) {
+	if err := fsStatRequest(conn, name, feat); err != nil {
+		return nil, err
+	}
+	st, err := fsStatResponse(conn, name, feat)
+	if err != nil {
+		return nil, err
+	}
+	return fsStatResult(name, st, feat)
+}
+
+func fsStatRequest(w io.Writer, name string, feat fsFeat) error {
+	id := syncID_LSTAT_V1
+	if feat.statV2 {
+		id = syncID_LSTAT_V2
+	}
+	if err := syncRequest(w, id, "/"+name); err != nil {
+		return &fs.PathError{
 			Op:   "stat",
 			Path: name,
 			Err:  err,
 		}
 	}
+	return nil
+}
+
+// fsStatResponse reads the response to a stat request. If an error is
+// returned, the connection is in an unknown state. Errors for the file itself
+// are returned by fsStatResult.
+func fsStatResponse(conn net.Conn, name string, feat fsFeat) (*sync_stat_v2, error) {
+	if feat.statV2 {
+		st, err := syncResponseObject[sync_stat_v2](conn, syncID_LSTAT_V2)
+		if err != nil {
+			return nil, &fs.PathError{
+				Op:   "stat",
+				Path: name,
+				Err:  err,
+			}
+		}
+		return st, nil
+	}
 	st, err := syncResponseObject[sync_stat_v1](conn, syncID_LSTAT_V1)
 	if err != nil {
 		return nil, &fs.PathError{
@@ -239,7 +343,19 @@ func fsStat(conn net.Conn, name string) (fs.FileInfo, error) {
 			Err:  err,
 		}
 	}
-	if *st == (sync_stat_v1{}) {
+	return syncStatV1(st), nil
+}
+
+func fsStatResult(name string, st *sync_stat_v2, feat fsFeat) (fs.FileInfo, error) {
+	if feat.statV2 {
+		if st.Error != 0 {
+			return nil, &fs.PathError{
+				Op:   "stat",
+				Path: name,
+				Err:  syncErrno(st.Error),
+			}
+		}
+	} else if *st == (sync_stat_v2{}) {
 		return nil, &fs.PathError{
 			Op:   "stat",
 			Path: name,
@@ -264,53 +380,83 @@ func (c *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	}
 	defer c.putConn(conn)
 
-	return fsReadDir(conn, name)
+	return fsReadDir(conn, name, c.fsFeat())
 }
 
-func fsReadDir(conn net.Conn, name string) ([]fs.DirEntry, error) {
-	if err := syncRequest(conn, syncID_LIST_V1, "/"+name); err != nil {
-		return nil, &fs.PathError{
+func fsReadDir(conn net.Conn, name string, feat fsFeat) ([]fs.DirEntry, error) {
+	if err := fsReadDirRequest(conn, name, feat); err != nil {
+		return nil, err
+	}
+	de, seen, err := fsReadDirResponse(conn, name, feat)
+	if err != nil {
+		return nil, err
+	}
+	if !seen {
+		st, err := fsStat(conn, name, feat)
+		if err := fsReadDirCheck(name, st, err); err != nil {
+			return nil, err
+		}
+	}
+	return de, nil
+}
+
+func fsReadDirRequest(w io.Writer, name string, feat fsFeat) error {
+	id := syncID_LIST_V1
+	if feat.lsV2 {
+		id = syncID_LIST_V2
+	}
+	if err := syncRequest(w, id, "/"+name); err != nil {
+		return &fs.PathError{
 			Op:   "readdir",
 			Path: name,
 			Err:  err,
 		}
 	}
+	return nil
+}
 
-	var de []fs.DirEntry
-	var seen bool
+// fsReadDirResponse reads the response to a LIST request. If seen is false,
+// the directory may not exist, and fsReadDirCheck should be called with the
+// result of a stat.
+func fsReadDirResponse(conn net.Conn, name string, feat fsFeat) (de []fs.DirEntry, seen bool, err error) {
 	for {
-		st, err := syncResponseObject[sync_dent_v1](conn, syncID_DENT_V1)
-		if err != nil {
-			return nil, &fs.PathError{
-				Op:   "readdirent",
-				Path: name,
-				Err:  err,
+		var (
+			st      *sync_stat_v2
+			namelen uint32
+		)
+		if feat.lsV2 {
+			dent, err := syncResponseObject[sync_dent_v2](conn, syncID_DENT_V2)
+			if err != nil {
+				return nil, seen, &fs.PathError{
+					Op:   "readdirent",
+					Path: name,
+					Err:  err,
+				}
 			}
-		}
-		if st == nil {
-			if !seen {
-				if st, err := fsStat(conn, name); err != nil {
-					if err, ok := err.(*fs.PathError); ok {
-						err.Op = "readdirent"
-						return nil, err
-					}
-					return nil, err
-				} else if !st.IsDir() {
-					return nil, &fs.PathError{
-						Op:   "readdirent",
-						Path: name,
-						Err:  errNotDirectory,
-					}
+			if dent != nil {
+				st, namelen = syncDentV2(dent), dent.Namelen
+			}
+		} else {
+			dent, err := syncResponseObject[sync_dent_v1](conn, syncID_DENT_V1)
+			if err != nil {
+				return nil, seen, &fs.PathError{
+					Op:   "readdirent",
+					Path: name,
+					Err:  err,
 				}
-				// could be an empty directory or not found, no way to tell reliably with v1
 			}
+			if dent != nil {
+				st, namelen = syncDentV1(dent), dent.Namelen
+			}
+		}
+		if st == nil {
 			break
 		} else {
 			seen = true
 		}
-		nb := make([]byte, st.Namelen)
+		nb := make([]byte, namelen)
 		if _, err := io.ReadFull(conn, nb); err != nil {
-			return nil, &fs.PathError{
+			return nil, seen, &fs.PathError{
 				Op:   "readdirentname",
 				Path: name,
 				Err:  err,
@@ -319,9 +465,32 @@ func fsReadDir(conn net.Conn, name string) ([]fs.DirEntry, error) {
 		if string(nb) == "." || string(nb) == ".." {
 			continue
 		}
+		if st.Error != 0 {
+			continue // the file was probably deleted after it was listed
+		}
 		de = append(de, &fsDirEntry{name: string(nb), st: st})
 	}
-	return de, nil
+	return de, seen, nil
+}
+
+// fsReadDirCheck checks the result of a stat for a directory which didn't
+// return any entries.
+func fsReadDirCheck(name string, st fs.FileInfo, err error) error {
+	if err != nil {
+		if err, ok := err.(*fs.PathError); ok {
+			err.Op = "readdirent"
+			return err
+		}
+		return err
+	} else if !st.IsDir() {
+		return &fs.PathError{
+			Op:   "readdirent",
+			Path: name,
+			Err:  ErrNotDirectory,
+		}
+	}
+	// could be an empty directory or not found, no way to tell reliably with v1
+	return nil
 }
 
 func (c *FS) ReadFile(name string) ([]byte, error) {
@@ -384,14 +553,18 @@ func (c *FS) ReadFile(name string) ([]byte, error) {
 type fsFile struct {
 	c    *FS
 	name string
-	st   *sync_stat_v1
+	st   *sync_stat_v2
 
 	mu   sync.Mutex
 	conn net.Conn
 	buf  bytes.Buffer
 	er   error
+	off  int64 // logical offset
+	pos  int64 // offset of the start of buf in the stream
 }
 
+var _ io.ReadSeeker = (*fsFile)(nil)
+
 func (f *fsFile) Stat() (fs.FileInfo, error) {
 	return &fsFileInfo{name: path.Base(f.name), st: f.st}, nil
 }
@@ -404,14 +577,48 @@ func (f *fsFile) Read(p []byte) (int, error) {
 		return 0, &fs.PathError{
 			Op:   "read",
 			Path: f.name,
-			Err:  errIsDirectory,
+			Err:  ErrIsDirectory,
 		}
 	}
-	if f.er != nil {
-		return 0, f.er
-	}
 
-	if f.buf.Len() == 0 {
+	for {
+		if f.buf.Len() != 0 {
+			// discard data if we seeked forwards
+			if n := f.off - f.pos; n > 0 {
+				n = min(n, int64(f.buf.Len()))
+				f.buf.Next(int(n))
+				f.pos += n
+				continue
+			}
+
+			// read from our buffered chunk
+			n, _ := f.buf.Read(p)
+			f.pos += int64(n)
+			f.off += int64(n)
+			return n, nil
+		}
+		if f.er != nil {
+			return 0, f.er
+		}
+
+		// we seeked backwards
+		if f.conn == nil {
+			conn, err := f.c.getConn()
+			if err != nil {
+				return 0, err
+			}
+			id := syncID_RECV_V1
+			if err := syncRequest(conn, id, "/"+f.name); err != nil {
+				f.c.delConn(conn)
+				return 0, &fs.PathError{
+					Op:   "read",
+					Path: f.name,
+					Err:  fmt.Errorf("do %s: %w", id, err),
+				}
+			}
+			f.conn = conn
+		}
+
 		// get another chunk
 		st, err := syncResponseObject[sync_data](f.conn, syncID_DATA)
 		if err != nil {
@@ -448,9 +655,58 @@ func (f *fsFile) Read(p []byte) (int, error) {
 			f.buf.Write(f.buf.AvailableBuffer()[:st.Size])
 		}
 	}
+}
+
+// Seek sets the offset for the next Read. Since the sync protocol can only
+// read files from the beginning, seeking forwards discards data, and seeking
+// backwards re-opens the file.
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if syncMode(f.st.Mode).IsDir() {
+		return 0, &fs.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  ErrIsDirectory,
+		}
+	}
+	if f.er == fs.ErrClosed {
+		return 0, f.er
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(f.st.Size)
+	default:
+		return 0, &fs.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  fs.ErrInvalid,
+		}
+	}
 
-	// read from our buffered chunk
-	return f.buf.Read(p)
+	if offset < f.pos {
+		if f.conn != nil {
+			f.c.delConn(f.conn) // don't put a conn in a bad state back
+			f.conn = nil
+		}
+		f.buf.Reset()
+		f.er = nil
+		f.pos = 0
+	}
+	f.off = offset
+	return offset, nil
 }
 
 func (f *fsFile) ReadDir(n int) ([]fs.DirEntry, error) {
@@ -458,7 +714,7 @@ func (f *fsFile) ReadDir(n int) ([]fs.DirEntry, error) {
 		return nil, &fs.PathError{
 			Op:   "readdir",
 			Path: f.name,
-			Err:  errNotDirectory,
+			Err:  ErrNotDirectory,
 		}
 	}
 	return f.c.ReadDir(f.name)
@@ -471,14 +727,37 @@ func (f *fsFile) Close() error {
 	if f.conn != nil {
 		f.c.delConn(f.conn) // don't put a conn in a bad state back
 		f.conn = nil
-		f.er = fs.ErrClosed
 	}
+	f.er = fs.ErrClosed
 	return nil
 }
 
+// FileStat contains the raw file information returned by the device. It is
+// returned by the Sys method of the fs.FileInfo values from FS.
+//
+// If the device does not support stat_v2 (or ls_v2 for directory entries), only
+// Mode, Size, and Mtime are set.
+type FileStat struct {
+	Dev   uint64
+	Ino   uint64
+	Mode  uint32 // st_mode, see FileMode
+	Nlink uint32
+	Uid   uint32
+	Gid   uint32
+	Size  uint64
+	Atime int64 // unix seconds
+	Mtime int64 // unix seconds
+	Ctime int64 // unix seconds
+}
+
+// FileMode converts the st_mode to a fs.FileMode.
+func (s *FileStat) FileMode() fs.FileMode {
+	return syncMode(s.Mode)
+}
+
 type fsFileInfo struct {
 	name string
-	st   *sync_stat_v1
+	st   *sync_stat_v2
 }
 
 func (f *fsFileInfo) Name() string {
@@ -502,12 +781,12 @@ func (f *fsFileInfo) IsDir() bool {
 }
 
 func (f *fsFileInfo) Sys() any {
-	return nil
+	return syncFileStat(f.st)
 }
 
 type fsDirEntry struct {
 	name string
-	st   *sync_dent_v1
+	st   *sync_stat_v2
 }
 
 func (f *fsDirEntry) Name() string {
@@ -539,5 +818,5 @@ func (f *fsDirEntry) ModTime() time.Time {
 }
 
 func (f *fsDirEntry) Sys() any {
-	return nil
+	return syncFileStat(f.st)
 }
@@ -0,0 +1,542 @@
+//go:build linux
+
+// Package fusefs serves the filesystem of an ADB device over FUSE.
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/bits"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	ffs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+// Options contains options for Mount.
+type Options struct {
+	// Timeout is how long the kernel caches attributes and directory entries
+	// for. If zero, 1 second is used. If negative, nothing is cached.
+	Timeout time.Duration
+
+	// NegativeTimeout is how long the kernel caches failed lookups for. If
+	// zero, nothing is cached.
+	NegativeTimeout time.Duration
+
+	// ReadAhead is the maximum number of bytes the kernel will read ahead. If
+	// zero, 1 MiB is used.
+	ReadAhead int
+
+	// ReadOnly mounts the filesystem read-only.
+	ReadOnly bool
+
+	// AllowOther allows other users to access the filesystem.
+	AllowOther bool
+
+	// Debug logs all FUSE requests.
+	Debug bool
+}
+
+// Mount mounts fsys at mountpoint. The returned server should be unmounted
+// when done, and Wait can be used to wait for it to be unmounted.
+//
+// Kernel requests are handled concurrently using the connection pool of fsys.
+// Files opened for writing are buffered in a local temporary file, and written
+// to the device when flushed or released.
+func Mount(fsys *adbfs.FS, mountpoint string, opts *Options) (*fuse.Server, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second
+	} else if o.Timeout < 0 {
+		o.Timeout = 0
+	}
+	if o.ReadAhead == 0 {
+		o.ReadAhead = 1024 * 1024
+	}
+
+	r := &root{fs: fsys}
+	if fi, err := fsys.Stat("."); err != nil {
+		return nil, err
+	} else {
+		r.dev = bits.RotateLeft64(fi.Sys().(*adbfs.FileStat).Dev, 32)
+	}
+
+	mo := fuse.MountOptions{
+		AllowOther:   o.AllowOther,
+		MaxReadAhead: o.ReadAhead,
+		Name:         "adbfs",
+		Debug:        o.Debug,
+		DirectMount:  true,
+	}
+	if o.ReadOnly {
+		mo.Options = append(mo.Options, "ro")
+	}
+	return ffs.Mount(mountpoint, &node{root: r}, &ffs.Options{
+		MountOptions:    mo,
+		EntryTimeout:    &o.Timeout,
+		AttrTimeout:     &o.Timeout,
+		NegativeTimeout: &o.NegativeTimeout,
+		NullPermissions: true,
+	})
+}
+
+type root struct {
+	fs  *adbfs.FS
+	dev uint64 // of the root, rotated
+}
+
+// ino returns a unique inode number for st, or zero if the device doesn't
+// provide one. Inode numbers for the filesystem containing the root are
+// preserved.
+func (r *root) ino(st *adbfs.FileStat) uint64 {
+	if st.Ino == 0 {
+		return 0
+	}
+	return st.Ino ^ bits.RotateLeft64(st.Dev, 32) ^ r.dev
+}
+
+// node is a file on the device.
+type node struct {
+	ffs.Inode
+	root *root
+}
+
+var (
+	_ ffs.NodeLookuper   = (*node)(nil)
+	_ ffs.NodeGetattrer  = (*node)(nil)
+	_ ffs.NodeSetattrer  = (*node)(nil)
+	_ ffs.NodeReaddirer  = (*node)(nil)
+	_ ffs.NodeOpener     = (*node)(nil)
+	_ ffs.NodeCreater    = (*node)(nil)
+	_ ffs.NodeReadlinker = (*node)(nil)
+	_ ffs.NodeSymlinker  = (*node)(nil)
+	_ ffs.NodeMkdirer    = (*node)(nil)
+	_ ffs.NodeUnlinker   = (*node)(nil)
+	_ ffs.NodeRmdirer    = (*node)(nil)
+	_ ffs.NodeRenamer    = (*node)(nil)
+	_ ffs.NodeStatfser   = (*node)(nil)
+)
+
+// name returns the fs path of the node.
+func (n *node) name() string {
+	if p := n.Path(nil); p != "" {
+		return p
+	}
+	return "."
+}
+
+func (n *node) child(name string) string {
+	return path.Join(n.name(), name)
+}
+
+// newChild stats name in n, returning a new inode for it.
+func (n *node) newChild(ctx context.Context, name string, out *fuse.EntryOut) (*ffs.Inode, syscall.Errno) {
+	fi, err := n.root.fs.Stat(n.child(name))
+	if err != nil {
+		return nil, errno(err)
+	}
+	st := fi.Sys().(*adbfs.FileStat)
+	n.root.attr(&out.Attr, st)
+	return n.NewInode(ctx, &node{root: n.root}, ffs.StableAttr{
+		Mode: st.Mode & syscall.S_IFMT,
+		Ino:  n.root.ino(st),
+	}), 0
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*ffs.Inode, syscall.Errno) {
+	return n.newChild(ctx, name, out)
+}
+
+func (n *node) Getattr(ctx context.Context, f ffs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if f, ok := f.(*writeHandle); ok {
+		return f.Getattr(ctx, out)
+	}
+	fi, err := n.root.fs.Stat(n.name())
+	if err != nil {
+		return errno(err)
+	}
+	n.root.attr(&out.Attr, fi.Sys().(*adbfs.FileStat))
+	return 0
+}
+
+func (n *node) Setattr(ctx context.Context, f ffs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	name := n.name()
+	if _, ok := in.GetUID(); ok {
+		return syscall.EPERM
+	}
+	if _, ok := in.GetGID(); ok {
+		return syscall.EPERM
+	}
+	if size, ok := in.GetSize(); ok {
+		if f, ok := f.(*writeHandle); ok {
+			if errno := f.truncate(int64(size)); errno != 0 {
+				return errno
+			}
+		} else if err := n.root.fs.Truncate(name, int64(size)); err != nil {
+			return errno(err)
+		}
+	}
+	if mode, ok := in.GetMode(); ok {
+		if err := n.root.fs.Chmod(name, fs.FileMode(mode&0777)|modeSpecial(mode)); err != nil {
+			return errno(err)
+		}
+	}
+	atime, aok := in.GetATime()
+	mtime, mok := in.GetMTime()
+	if aok || mok {
+		if err := n.root.fs.Chtimes(name, atime, mtime); err != nil {
+			return errno(err)
+		}
+	}
+	return n.Getattr(ctx, f, out)
+}
+
+func (n *node) Readdir(ctx context.Context) (ffs.DirStream, syscall.Errno) {
+	de, err := n.root.fs.ReadDir(n.name())
+	if err != nil {
+		return nil, errno(err)
+	}
+	ents := make([]fuse.DirEntry, 0, len(de))
+	for _, d := range de {
+		ent := fuse.DirEntry{
+			Name: d.Name(),
+		}
+		if fi, err := d.Info(); err == nil {
+			st := fi.Sys().(*adbfs.FileStat)
+			ent.Mode = st.Mode
+			ent.Ino = n.root.ino(st)
+		}
+		ents = append(ents, ent)
+	}
+	return ffs.NewListDirStream(ents), 0
+}
+
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.root.fs.Readlink(n.name())
+	if err != nil {
+		return nil, errno(err)
+	}
+	return []byte(target), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (ffs.FileHandle, uint32, syscall.Errno) {
+	name := n.name()
+	if flags&syscall.O_ACCMODE == syscall.O_RDONLY {
+		f, err := n.root.fs.Open(name)
+		if err != nil {
+			return nil, 0, errno(err)
+		}
+		return &readHandle{name: name, r: adbfs.NewReaderAt(f.(io.ReadSeekCloser))}, 0, 0
+	}
+
+	fi, err := n.root.fs.Stat(name)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	if fi.IsDir() {
+		return nil, 0, syscall.EISDIR
+	}
+	f, errno := newWriteHandle(n.root, name, fi.Mode().Perm(), flags&syscall.O_TRUNC == 0)
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		f.dirty = true
+	}
+	return f, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*ffs.Inode, ffs.FileHandle, uint32, syscall.Errno) {
+	f, errno := newWriteHandle(n.root, n.child(name), fs.FileMode(mode&0777), false)
+	if errno != 0 {
+		return nil, nil, 0, errno
+	}
+	f.dirty = true
+	if errno := f.send(); errno != 0 { // so it exists before it's released
+		f.Release(ctx)
+		return nil, nil, 0, errno
+	}
+	ch, errno := n.newChild(ctx, name, out)
+	if errno != 0 {
+		f.Release(ctx)
+		return nil, nil, 0, errno
+	}
+	return ch, f, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (n *node) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*ffs.Inode, syscall.Errno) {
+	if err := n.root.fs.Symlink(target, n.child(name)); err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, name, out)
+}
+
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*ffs.Inode, syscall.Errno) {
+	if err := n.root.fs.Mkdir(n.child(name), fs.FileMode(mode&0777)); err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, name, out)
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	name = n.child(name)
+	if fi, err := n.root.fs.Stat(name); err != nil {
+		return errno(err)
+	} else if fi.IsDir() {
+		return syscall.EISDIR
+	}
+	return errno(n.root.fs.Remove(name))
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	name = n.child(name)
+	if fi, err := n.root.fs.Stat(name); err != nil {
+		return errno(err)
+	} else if !fi.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return errno(n.root.fs.Remove(name))
+}
+
+func (n *node) Rename(ctx context.Context, name string, newParent ffs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags != 0 {
+		return syscall.EINVAL // RENAME_NOREPLACE and RENAME_EXCHANGE can't be done atomically
+	}
+	return errno(n.root.fs.Rename(n.child(name), path.Join(newParent.(*node).name(), newName)))
+}
+
+// Statfs reports the filesystem containing the node using FS.StatFS.
+func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	st, err := n.root.fs.StatFS(n.name())
+	if err != nil {
+		return errno(err)
+	}
+	bs := max(st.BlockSize, 1)
+	out.Bsize = uint32(bs)
+	out.Frsize = uint32(bs)
+	out.Blocks = uint64(st.Total / bs)
+	out.Bfree = uint64(st.Free / bs)
+	out.Bavail = uint64(st.Available / bs)
+	out.Files = uint64(st.Files)
+	out.Ffree = uint64(st.FilesFree)
+	out.NameLen = uint32(st.NameMax)
+	return 0
+}
+
+// attr fills out from st.
+func (r *root) attr(out *fuse.Attr, st *adbfs.FileStat) {
+	out.Ino = r.ino(st)
+	out.Mode = st.Mode
+	out.Nlink = max(st.Nlink, 1)
+	out.Uid = st.Uid
+	out.Gid = st.Gid
+	out.Size = st.Size
+	out.Blocks = (st.Size + 511) / 512
+	out.Blksize = 4096
+	out.Atime = uint64(max(st.Atime, 0))
+	out.Mtime = uint64(max(st.Mtime, 0))
+	out.Ctime = uint64(max(st.Ctime, 0))
+}
+
+// readHandle is a file opened for reading.
+type readHandle struct {
+	name string
+	r    *adbfs.ReaderAt
+}
+
+var (
+	_ ffs.FileReader   = (*readHandle)(nil)
+	_ ffs.FileReleaser = (*readHandle)(nil)
+)
+
+func (f *readHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := f.r.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *readHandle) Release(ctx context.Context) syscall.Errno {
+	return errno(f.r.Close())
+}
+
+// writeHandle is a file opened for writing, buffered in a temporary file.
+type writeHandle struct {
+	root *root
+	name string
+	mode fs.FileMode
+
+	mu    sync.Mutex
+	tmp   *os.File
+	dirty bool
+}
+
+var (
+	_ ffs.FileReader    = (*writeHandle)(nil)
+	_ ffs.FileWriter    = (*writeHandle)(nil)
+	_ ffs.FileGetattrer = (*writeHandle)(nil)
+	_ ffs.FileFlusher   = (*writeHandle)(nil)
+	_ ffs.FileFsyncer   = (*writeHandle)(nil)
+	_ ffs.FileReleaser  = (*writeHandle)(nil)
+)
+
+// newWriteHandle creates a temporary file for writing to name, copying the
+// current contents if load is true.
+func newWriteHandle(r *root, name string, mode fs.FileMode, load bool) (*writeHandle, syscall.Errno) {
+	tmp, err := os.CreateTemp("", "adbfs-fuse-*")
+	if err != nil {
+		return nil, errno(err)
+	}
+	os.Remove(tmp.Name()) // we only need the fd
+
+	if load {
+		f, err := r.fs.Open(name)
+		if err == nil {
+			_, err = io.Copy(tmp, f)
+			f.Close()
+		}
+		if err != nil {
+			tmp.Close()
+			return nil, errno(err)
+		}
+	}
+	return &writeHandle{root: r, name: name, mode: mode, tmp: tmp}, 0
+}
+
+func (f *writeHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.tmp.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *writeHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.tmp.WriteAt(data, off)
+	if n != 0 {
+		f.dirty = true
+	}
+	if err != nil {
+		return uint32(n), errno(err)
+	}
+	return uint32(n), 0
+}
+
+func (f *writeHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	fi, err := f.root.fs.Stat(f.name)
+	if err != nil {
+		return errno(err)
+	}
+	f.root.attr(&out.Attr, fi.Sys().(*adbfs.FileStat))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if lfi, err := f.tmp.Stat(); err == nil {
+		out.Size = uint64(lfi.Size())
+		out.Blocks = (out.Size + 511) / 512
+	}
+	return 0
+}
+
+func (f *writeHandle) truncate(size int64) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.tmp.Truncate(size); err != nil {
+		return errno(err)
+	}
+	f.dirty = true
+	return 0
+}
+
+func (f *writeHandle) Flush(ctx context.Context) syscall.Errno {
+	return f.send()
+}
+
+func (f *writeHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return f.send()
+}
+
+func (f *writeHandle) Release(ctx context.Context) syscall.Errno {
+	errno := f.send()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tmp.Close()
+	return errno
+}
+
+// send writes the file to the device if it was modified.
+func (f *writeHandle) send() syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dirty {
+		return 0
+	}
+	if err := f.root.fs.Send(f.name, io.NewSectionReader(f.tmp, 0, 1<<63-1), f.mode, time.Time{}); err != nil {
+		return errno(err)
+	}
+	f.dirty = false
+	return 0
+}
+
+// modeSpecial converts the setuid, setgid, and sticky bits from st_mode.
+func modeSpecial(mode uint32) fs.FileMode {
+	var m fs.FileMode
+	if mode&syscall.S_ISUID != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// errno converts err into an errno for the kernel.
+func errno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		return e
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, adbfs.ErrNotDirectory):
+		return syscall.ENOTDIR
+	case errors.Is(err, adbfs.ErrIsDirectory):
+		return syscall.EISDIR
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL
+	}
+	return syscall.EIO
+}
@@ -1,3 +1,15 @@
 module github.com/pgaskin/go-adbfs
 
 go 1.22.3
+
+require (
+	github.com/hanwen/go-fuse/v2 v2.9.0
+	github.com/pkg/sftp v1.13.7
+	golang.org/x/net v0.35.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,153 @@
+package adbfs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// hashCommand returns the device command used to compute algo.
+func hashCommand(algo crypto.Hash) (string, bool) {
+	switch algo {
+	case crypto.MD5:
+		return "md5sum", true
+	case crypto.SHA1:
+		return "sha1sum", true
+	case crypto.SHA224:
+		return "sha224sum", true
+	case crypto.SHA256:
+		return "sha256sum", true
+	case crypto.SHA384:
+		return "sha384sum", true
+	case crypto.SHA512:
+		return "sha512sum", true
+	}
+	return "", false
+}
+
+// Hash computes the digest of the named file on the device using the
+// corresponding command (e.g., sha256sum for crypto.SHA256). MD5, SHA1, SHA224,
+// SHA256, SHA384, and SHA512 are supported, but the device may not have
+// commands for all of them.
+func (c *FS) Hash(name string, algo crypto.Hash) ([]byte, error) {
+	sum, errs := c.HashMany([]string{name}, algo)
+	return sum[0], errs[0]
+}
+
+// HashMany is like Hash, but for multiple files. The files are hashed using a
+// single shell command.
+func (c *FS) HashMany(names []string, algo crypto.Hash) ([][]byte, []error) {
+	return c.hashMany(context.Background(), names, algo)
+}
+
+// HashTree computes the digest of every regular file in the tree rooted at
+// root, returning them by name. If any file cannot be hashed, an error is
+// returned.
+func (c *FS) HashTree(ctx context.Context, root string, algo crypto.Hash) (map[string][]byte, error) {
+	var names []string
+	if err := c.Walk(ctx, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			names = append(names, name)
+		}
+		return nil
+	}, nil); err != nil {
+		return nil, err
+	}
+
+	const batch = 512
+	sums := make(map[string][]byte, len(names))
+	for len(names) != 0 {
+		n := min(len(names), batch)
+		sum, errs := c.hashMany(ctx, names[:n], algo)
+		for i, name := range names[:n] {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			sums[name] = sum[i]
+		}
+		names = names[n:]
+	}
+	return sums, nil
+}
+
+func (c *FS) hashMany(ctx context.Context, names []string, algo crypto.Hash) ([][]byte, []error) {
+	var (
+		sum  = make([][]byte, len(names))
+		errs = make([]error, len(names))
+		idx  = make([]int, 0, len(names))
+	)
+	cmd, ok := hashCommand(algo)
+	for i, name := range names {
+		switch {
+		case !ok:
+			errs[i] = &fs.PathError{
+				Op:   "hash",
+				Path: name,
+				Err:  fmt.Errorf("unsupported hash algorithm %s", algo),
+			}
+		case !fs.ValidPath(name):
+			errs[i] = &fs.PathError{
+				Op:   "hash",
+				Path: name,
+				Err:  fs.ErrInvalid,
+			}
+		default:
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return sum, errs
+	}
+
+	// the script is passed over stdin to avoid command length limits, and
+	// each result is NUL-terminated since names may contain newlines
+	var script strings.Builder
+	script.WriteString("for f in")
+	for _, i := range idx {
+		script.WriteString(" " + shellQuote("/"+names[i]))
+	}
+	script.WriteString("; do h=$(" + cmd + " 2>&1 < \"$f\") && printf '%s\\0' \"${h%% *}\" || printf '!%s\\0' \"$h\"; done\n")
+
+	buf, err := c.shell(ctx, "sh", strings.NewReader(script.String()))
+	res := bytes.Split(buf, []byte{0})
+	if err == nil && len(res) != len(idx)+1 {
+		err = errors.New("unexpected output from " + cmd)
+	}
+	for j, i := range idx {
+		if err != nil {
+			errs[i] = &fs.PathError{
+				Op:   "hash",
+				Path: names[i],
+				Err:  shellFileError(err),
+			}
+			continue
+		}
+		if msg, ok := bytes.CutPrefix(res[j], []byte{'!'}); ok {
+			errs[i] = &fs.PathError{
+				Op:   "hash",
+				Path: names[i],
+				Err:  shellFileError(&ExitError{Command: cmd, ExitCode: 1, Stderr: msg}),
+			}
+			continue
+		}
+		b, err := hex.DecodeString(string(res[j]))
+		if err != nil || len(b) != algo.Size() {
+			errs[i] = &fs.PathError{
+				Op:   "hash",
+				Path: names[i],
+				Err:  fmt.Errorf("invalid %s output %q", cmd, res[j]),
+			}
+			continue
+		}
+		sum[i] = b
+	}
+	return sum, errs
+}
@@ -0,0 +1,309 @@
+// Package httpfs serves the filesystem of an ADB device over HTTP.
+package httpfs
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+// Options contains options for New.
+type Options struct {
+	// Upload allows files to be written with PUT requests, or uploaded to a
+	// directory with multipart POST requests (which the HTML directory
+	// listing provides a form for). POST requests from pages on other origins
+	// are rejected.
+	Upload bool
+}
+
+// Handler serves files from a device.
+//
+// Files support range requests, but since the sync protocol can only read
+// files from the start, the skipped data still needs to be transferred from
+// the device.
+//
+// Directory listings are returned as HTML, or as JSON if the format query
+// parameter is "json" or the request accepts application/json.
+type Handler struct {
+	fs     *adbfs.FS
+	upload bool
+}
+
+// New creates a new handler serving fsys.
+func New(fsys *adbfs.FS, opts *Options) *Handler {
+	h := &Handler{fs: fsys}
+	if opts != nil {
+		h.upload = opts.Upload
+	}
+	return h
+}
+
+// Entry is a directory entry in a JSON directory listing.
+type Entry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveGet(w, r, name)
+	case http.MethodPut:
+		if !h.upload {
+			http.Error(w, "Uploads not enabled", http.StatusMethodNotAllowed)
+			return
+		}
+		h.servePut(w, r, name)
+	case http.MethodPost:
+		if !h.upload {
+			http.Error(w, "Uploads not enabled", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			// multipart POSTs don't need a CORS preflight, so any page could
+			// otherwise upload files
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		h.servePost(w, r, name)
+	default:
+		if h.upload {
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD")
+		}
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveGet(w http.ResponseWriter, r *http.Request, name string) {
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+
+	f, err := h.fs.Open(name)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	// the size is the length of the target for symlinks, so we can't seek
+	if fi.Mode().Type() == fs.ModeSymlink {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+		if r.Method != http.MethodHead {
+			io.Copy(w, f)
+		}
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f.(io.ReadSeeker))
+}
+
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	de, err := h.fs.ReadDir(name)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	slices.SortFunc(de, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	ents := make([]Entry, 0, len(de))
+	for _, d := range de {
+		fi, err := d.Info()
+		if err != nil {
+			continue
+		}
+		ents = append(ents, Entry{
+			Name:    d.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode().String(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+
+	if r.URL.Query().Get("format") == "json" || acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Vary", "Accept")
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(ents)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	title := "/"
+	if name != "." {
+		title += name
+	}
+	if r.Method != http.MethodHead {
+		dirTmpl.Execute(w, map[string]any{
+			"Name":    title,
+			"Root":    name == ".",
+			"Upload":  h.upload,
+			"Entries": ents,
+		})
+	}
+}
+
+func (h *Handler) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "." || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Cannot PUT a directory", http.StatusBadRequest)
+		return
+	}
+	if err := h.fs.Send(name, r.Body, 0644, time.Time{}); err != nil {
+		serveError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) servePost(w http.ResponseWriter, r *http.Request, name string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fn := p.FileName()
+		if fn == "" {
+			continue
+		}
+		if fn = path.Base(fn); !fs.ValidPath(fn) || fn == "." {
+			http.Error(w, "Bad request: invalid filename", http.StatusBadRequest)
+			return
+		}
+		if err := h.fs.Send(path.Join(name, fn), p, 0644, time.Time{}); err != nil {
+			serveError(w, err)
+			return
+		}
+	}
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Permission denied", http.StatusForbidden)
+	case errors.Is(err, fs.ErrInvalid), errors.Is(err, adbfs.ErrIsDirectory), errors.Is(err, adbfs.ErrNotDirectory):
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sameOrigin checks whether a request was made by a page from the same origin,
+// or not by a browser at all.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host != "" && u.Host == r.Host
+	}
+	return true
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(v)); err == nil && mt == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+var dirTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"href": func(e Entry) string {
+		u := (&url.URL{Path: e.Name}).String()
+		if e.IsDir {
+			u += "/"
+		}
+		return u
+	},
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 0 1em 0 0; font-family: monospace; white-space: nowrap; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{- if .Upload}}
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple required>
+<input type="submit" value="Upload">
+</form>
+{{- end}}
+<table>
+<tr><th>Mode</th><th>Size</th><th>Modified</th><th>Name</th></tr>
+{{- if not .Root}}
+<tr><td></td><td></td><td></td><td><a href="../">../</a></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td>{{.Mode}}</td><td class="size">{{.Size}}</td><td>{{time .ModTime}}</td><td><a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,48 @@
+package httpfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		site, origin string
+		ok           bool
+	}{
+		{"", "", true},
+		{"same-origin", "", true},
+		{"none", "", true},
+		{"cross-site", "", false},
+		{"same-site", "", false},
+		{"cross-site", "http://example.com", false},
+		{"", "http://example.com", true},
+		{"", "https://example.com", true},
+		{"", "http://example.com:8080", false},
+		{"", "http://evil.example", false},
+		{"", "null", false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/", nil)
+		if tc.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tc.site)
+		}
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if ok := sameOrigin(r); ok != tc.ok {
+			t.Errorf("Sec-Fetch-Site=%q Origin=%q: expected %t, got %t", tc.site, tc.origin, tc.ok, ok)
+		}
+	}
+}
+
+func TestUploadCrossOrigin(t *testing.T) {
+	h := New(nil, &Options{Upload: true})
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/sdcard/", nil)
+	r.Header.Set("Origin", "http://evil.example")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
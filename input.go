@@ -0,0 +1,188 @@
+package adbfs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Input injects input events and automates the UI using a persistent shell
+// session. It is safe for concurrent use, but commands are run one at a time.
+type Input struct {
+	c *FS
+	s shellSession
+}
+
+// Input returns a new Input. It must be closed when no longer needed.
+func (c *FS) Input() *Input {
+	return &Input{c: c, s: shellSession{c: c}}
+}
+
+// Close closes the shell session.
+func (in *Input) Close() error {
+	return in.s.close()
+}
+
+// run runs a command, returning an error with the last line of output if it
+// fails.
+func (in *Input) run(ctx context.Context, op string, args ...string) ([]byte, error) {
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	buf, err := in.s.run(ctx, strings.Join(args, " "))
+	if err != nil {
+		if _, ok := err.(*ExitError); ok {
+			if msg := shellLastLine(buf); msg != "" {
+				return buf, fmt.Errorf("%s: %s", op, msg)
+			}
+		}
+		return buf, fmt.Errorf("%s: %w", op, err)
+	}
+	return buf, nil
+}
+
+// Tap taps the screen at the specified coordinates.
+func (in *Input) Tap(ctx context.Context, x, y int) error {
+	_, err := in.run(ctx, "tap", "input", "tap", strconv.Itoa(x), strconv.Itoa(y))
+	return err
+}
+
+// Swipe swipes from one point to another over the specified duration (or the
+// default if zero). If the points are the same, this is a long press.
+func (in *Input) Swipe(ctx context.Context, x1, y1, x2, y2 int, d time.Duration) error {
+	args := []string{"input", "swipe", strconv.Itoa(x1), strconv.Itoa(y1), strconv.Itoa(x2), strconv.Itoa(y2)}
+	if d > 0 {
+		args = append(args, strconv.FormatInt(d.Milliseconds(), 10))
+	}
+	_, err := in.run(ctx, "swipe", args...)
+	return err
+}
+
+// Text types text into the focused field. Only ASCII is supported, and %s is
+// typed as a space.
+func (in *Input) Text(ctx context.Context, s string) error {
+	// input text replaces %s with a space, but splits on literal spaces
+	s = strings.ReplaceAll(s, " ", "%s")
+	_, err := in.run(ctx, "text", "input", "text", s)
+	return err
+}
+
+// KeyEvent sends key events, specified either as key codes or names (e.g., 3
+// or KEYCODE_HOME).
+func (in *Input) KeyEvent(ctx context.Context, keys ...string) error {
+	_, err := in.run(ctx, "keyevent", append([]string{"input", "keyevent"}, keys...)...)
+	return err
+}
+
+// StartActivity starts an activity by component name (e.g.,
+// com.example/.MainActivity) and waits for it to launch.
+func (in *Input) StartActivity(ctx context.Context, component string) error {
+	buf, err := in.run(ctx, "start activity", "am", "start", "-W", "-n", component)
+	if err != nil {
+		return err
+	}
+	// am start exits with zero even if the activity couldn't be started
+	for _, line := range strings.Split(string(buf), "\n") {
+		if msg, ok := strings.CutPrefix(strings.TrimSpace(line), "Error: "); ok {
+			return fmt.Errorf("start activity: %s", msg)
+		}
+	}
+	return nil
+}
+
+// ForceStop force-stops a package.
+func (in *Input) ForceStop(ctx context.Context, pkg string) error {
+	_, err := in.run(ctx, "force-stop", "am", "force-stop", pkg)
+	return err
+}
+
+// UINode is a node in a UI hierarchy dump.
+type UINode struct {
+	Class       string
+	Package     string
+	Text        string
+	ResourceID  string
+	ContentDesc string
+	Bounds      image.Rectangle
+	Clickable   bool
+	Enabled     bool
+	Focused     bool
+	Checked     bool
+	Selected    bool
+	Attr        map[string]string // all attributes
+	Children    []*UINode
+}
+
+// Find returns the first node (depth-first, including n) for which fn returns
+// true, or nil.
+func (n *UINode) Find(fn func(*UINode) bool) *UINode {
+	if fn(n) {
+		return n
+	}
+	for _, c := range n.Children {
+		if m := c.Find(fn); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// DumpUI dumps the UI hierarchy of the current window using uiautomator. The
+// root node is the hierarchy element, which has no bounds.
+func (in *Input) DumpUI(ctx context.Context) (*UINode, error) {
+	name := fmt.Sprintf("data/local/tmp/adbfs-uidump-%d.xml", time.Now().UnixNano())
+
+	buf, err := in.run(ctx, "dump ui", "uiautomator", "dump", "/"+name)
+	if err != nil {
+		return nil, err
+	}
+	defer in.c.Remove(name)
+
+	// uiautomator exits with zero on failure, and the message has a typo
+	if !strings.Contains(string(buf), "dumped to") {
+		return nil, fmt.Errorf("dump ui: %s", shellLastLine(buf))
+	}
+	buf, err = in.c.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("dump ui: %w", err)
+	}
+
+	var x uiXMLNode
+	if err := xml.Unmarshal(buf, &x); err != nil {
+		return nil, fmt.Errorf("dump ui: %w", err)
+	}
+	return x.node(), nil
+}
+
+type uiXMLNode struct {
+	Attr     []xml.Attr  `xml:",any,attr"`
+	Children []uiXMLNode `xml:"node"`
+}
+
+func (x *uiXMLNode) node() *UINode {
+	n := &UINode{
+		Attr: make(map[string]string, len(x.Attr)),
+	}
+	for _, a := range x.Attr {
+		n.Attr[a.Name.Local] = a.Value
+	}
+	n.Class = n.Attr["class"]
+	n.Package = n.Attr["package"]
+	n.Text = n.Attr["text"]
+	n.ResourceID = n.Attr["resource-id"]
+	n.ContentDesc = n.Attr["content-desc"]
+	n.Clickable = n.Attr["clickable"] == "true"
+	n.Enabled = n.Attr["enabled"] == "true"
+	n.Focused = n.Attr["focused"] == "true"
+	n.Checked = n.Attr["checked"] == "true"
+	n.Selected = n.Attr["selected"] == "true"
+	fmt.Sscanf(n.Attr["bounds"], "[%d,%d][%d,%d]", &n.Bounds.Min.X, &n.Bounds.Min.Y, &n.Bounds.Max.X, &n.Bounds.Max.Y)
+	for i := range x.Children {
+		n.Children = append(n.Children, x.Children[i].node())
+	}
+	return n
+}
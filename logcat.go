@@ -0,0 +1,227 @@
+package adbfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://cs.android.com/android/platform/superproject/main/+/main:system/logging/liblog/include/log/log_read.h
+
+// LogPriority is the priority of a log message.
+type LogPriority uint8
+
+const (
+	LogVerbose LogPriority = 2
+	LogDebug   LogPriority = 3
+	LogInfo    LogPriority = 4
+	LogWarn    LogPriority = 5
+	LogError   LogPriority = 6
+	LogFatal   LogPriority = 7
+	LogSilent  LogPriority = 8
+)
+
+// String returns the letter used by logcat for the priority.
+func (p LogPriority) String() string {
+	if p >= LogVerbose && p <= LogSilent {
+		return string("VDIWEFS"[p-LogVerbose])
+	}
+	return strconv.Itoa(int(p))
+}
+
+// logBuffers contains the names of the log buffers by ID.
+var logBuffers = []string{"main", "radio", "events", "system", "crash", "stats", "security", "kernel"}
+
+// LogEntry is a log message.
+type LogEntry struct {
+	PID      int32
+	TID      uint32
+	Time     time.Time
+	UID      int32  // -1 if not known
+	Buffer   string // log buffer name (e.g., main), if known
+	Priority LogPriority
+	Tag      string
+	Message  string
+	Data     []byte // raw payload for binary buffers (events, stats, security)
+}
+
+// LogcatOptions contains options for Logcat.
+type LogcatOptions struct {
+	// Buffers to read (e.g., main, system, crash, events, all). If empty, the
+	// device's default buffers are used.
+	Buffers []string
+
+	// Filters are logcat filterspecs like "ActivityManager:I" or "*:S".
+	Filters []string
+
+	// Priority is the minimum priority for tags not matched by Filters. If
+	// zero, all priorities are included.
+	Priority LogPriority
+
+	// Since only includes messages at or after the time, if not zero.
+	Since time.Time
+
+	// Tail only includes the specified number of most recent messages, if
+	// not zero. It is ignored if Since is set.
+	Tail int
+
+	// Dump stops once the existing messages have been read instead of
+	// waiting for new ones.
+	Dump bool
+}
+
+func (o *LogcatOptions) args() string {
+	args := []string{"-B"}
+	if o != nil {
+		for _, b := range o.Buffers {
+			args = append(args, "-b", b)
+		}
+		switch {
+		case !o.Since.IsZero():
+			ns := o.Since.UnixNano()
+			args = append(args, "-T", fmt.Sprintf("%d.%03d", ns/1e9, ns%1e9/1e6))
+		case o.Tail > 0:
+			args = append(args, "-T", strconv.Itoa(o.Tail))
+		}
+		if o.Dump {
+			args = append(args, "-d")
+		}
+		args = append(args, o.Filters...)
+		if o.Priority != 0 {
+			args = append(args, "*:"+o.Priority.String())
+		}
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// Logcat streams log messages from the device using logcat -B, which outputs
+// the binary entries from logd. The entries channel is closed once logcat
+// exits or ctx is cancelled, after which exactly one error (nil if logcat
+// exited normally) is sent on the error channel.
+func (c *FS) Logcat(ctx context.Context, opts *LogcatOptions) (<-chan *LogEntry, <-chan error) {
+	var (
+		entries = make(chan *LogEntry, 64)
+		errc    = make(chan error, 1)
+	)
+	go func() {
+		err := c.logcat(ctx, opts, entries)
+		close(entries)
+		errc <- err
+	}()
+	return entries, errc
+}
+
+func (c *FS) logcat(ctx context.Context, opts *LogcatOptions, entries chan<- *LogEntry) error {
+	cmd := "logcat " + opts.args()
+
+	conn, err := adbConnectDeviceContext(ctx, c.addr, c.serial, "exec:"+cmd)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	br := bufio.NewReader(conn)
+	for first := true; ; first = false {
+		e, err := logRead(br)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if errors.Is(err, errLogInvalid) && first {
+				// probably an error message from logcat
+				buf, _ := io.ReadAll(io.LimitReader(br, 4096))
+				if msg := shellLastLine(buf); msg != "" {
+					return fmt.Errorf("logcat: %s", msg)
+				}
+			}
+			return fmt.Errorf("logcat: %w", err)
+		}
+		select {
+		case entries <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+var errLogInvalid = errors.New("invalid log entry header")
+
+// logRead reads a logger_entry. The header is peeked first so it is still
+// available if it turns out to be invalid.
+func logRead(br *bufio.Reader) (*LogEntry, error) {
+	hdr, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF && len(hdr) != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var (
+		size    = int(binary.LittleEndian.Uint16(hdr[0:]))
+		hdrSize = int(binary.LittleEndian.Uint16(hdr[2:]))
+	)
+	switch {
+	case hdrSize == 0:
+		hdrSize = 20 // v1, which had padding instead of the header size
+	case hdrSize < 24 || hdrSize > 64:
+		return nil, errLogInvalid
+	}
+
+	buf := make([]byte, hdrSize+size)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	e := &LogEntry{
+		PID:  int32(binary.LittleEndian.Uint32(buf[4:])),
+		TID:  binary.LittleEndian.Uint32(buf[8:]),
+		Time: time.Unix(int64(binary.LittleEndian.Uint32(buf[12:])), int64(binary.LittleEndian.Uint32(buf[16:]))),
+		UID:  -1,
+	}
+	lid := -1
+	if hdrSize >= 24 {
+		// v2 has euid here, but is identical to v3 otherwise, and was only
+		// briefly used
+		lid = int(binary.LittleEndian.Uint32(buf[20:]))
+	}
+	if hdrSize >= 28 {
+		e.UID = int32(binary.LittleEndian.Uint32(buf[24:]))
+	}
+	if lid >= 0 && lid < len(logBuffers) {
+		e.Buffer = logBuffers[lid]
+	}
+
+	payload := buf[hdrSize:]
+	switch e.Buffer {
+	case "events", "stats", "security":
+		e.Priority = LogInfo
+		e.Data = payload
+	default:
+		if len(payload) != 0 {
+			e.Priority = LogPriority(payload[0])
+			payload = payload[1:]
+		}
+		tag, msg, _ := bytes.Cut(payload, []byte{0})
+		e.Tag = string(tag)
+		e.Message = string(bytes.TrimRight(msg, "\x00"))
+	}
+	return e, nil
+}
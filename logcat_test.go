@@ -0,0 +1,149 @@
+package adbfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logEntry encodes a logger_entry with the specified header size (0 for v1).
+func logEntry(hdrSize int, lid, uid uint32, payload string) []byte {
+	n := hdrSize
+	if n == 0 {
+		n = 20
+	}
+	b := make([]byte, n)
+	binary.LittleEndian.PutUint16(b[0:], uint16(len(payload)))
+	binary.LittleEndian.PutUint16(b[2:], uint16(hdrSize))
+	binary.LittleEndian.PutUint32(b[4:], 123)         // pid
+	binary.LittleEndian.PutUint32(b[8:], 456)         // tid
+	binary.LittleEndian.PutUint32(b[12:], 1700000000) // sec
+	binary.LittleEndian.PutUint32(b[16:], 5000)       // nsec
+	if n >= 24 {
+		binary.LittleEndian.PutUint32(b[20:], lid)
+	}
+	if n >= 28 {
+		binary.LittleEndian.PutUint32(b[24:], uid)
+	}
+	return append(b, payload...)
+}
+
+func TestLogRead(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		in    []byte
+		entry *LogEntry
+		err   error
+	}{
+		{
+			name:  "V1",
+			in:    logEntry(0, 0, 0, "\x04tag\x00message\x00"),
+			entry: &LogEntry{UID: -1, Priority: LogInfo, Tag: "tag", Message: "message"},
+		},
+		{
+			name:  "V3",
+			in:    logEntry(24, 3, 0, "\x06tag\x00message\x00"),
+			entry: &LogEntry{UID: -1, Buffer: "system", Priority: LogError, Tag: "tag", Message: "message"},
+		},
+		{
+			name:  "V4",
+			in:    logEntry(28, 4, 10123, "\x05tag\x00message\x00"),
+			entry: &LogEntry{UID: 10123, Buffer: "crash", Priority: LogWarn, Tag: "tag", Message: "message"},
+		},
+		{
+			name:  "V4Events",
+			in:    logEntry(28, 2, 1000, "\x01\x02\x03\x04"),
+			entry: &LogEntry{UID: 1000, Buffer: "events", Priority: LogInfo, Data: []byte{1, 2, 3, 4}},
+		},
+		{
+			name:  "UnknownBuffer",
+			in:    logEntry(28, 99, 0, "\x03tag\x00message\x00"),
+			entry: &LogEntry{UID: 0, Priority: LogDebug, Tag: "tag", Message: "message"},
+		},
+		{
+			name:  "LargerHeader",
+			in:    logEntry(32, 0, 0, "\x02tag\x00message\x00"),
+			entry: &LogEntry{UID: 0, Buffer: "main", Priority: LogVerbose, Tag: "tag", Message: "message"},
+		},
+		{
+			name: "Empty",
+			in:   nil,
+			err:  io.EOF,
+		},
+		{
+			name: "TruncatedSize",
+			in:   []byte{1, 0},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "TruncatedHeader",
+			in:   logEntry(28, 0, 0, "")[:20],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "TruncatedPayload",
+			in:   bytes.TrimSuffix(logEntry(28, 0, 0, "\x04tag\x00message\x00"), []byte("message\x00")),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "BadHeaderSize",
+			in:   logEntry(22, 0, 0, ""),
+			err:  errLogInvalid,
+		},
+		{
+			name: "ErrorText",
+			in:   []byte("logcat: Unable to open log device '/dev/log/nope': No such file or directory\n"),
+			err:  errLogInvalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := logRead(bufio.NewReader(bytes.NewReader(tc.in)))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if tc.entry == nil {
+				return
+			}
+			tc.entry.PID, tc.entry.TID, tc.entry.Time = 123, 456, time.Unix(1700000000, 5000)
+			if e.PID != tc.entry.PID || e.TID != tc.entry.TID || !e.Time.Equal(tc.entry.Time) || e.UID != tc.entry.UID || e.Buffer != tc.entry.Buffer || e.Priority != tc.entry.Priority || e.Tag != tc.entry.Tag || e.Message != tc.entry.Message || !bytes.Equal(e.Data, tc.entry.Data) {
+				t.Errorf("expected %+v, got %+v", tc.entry, e)
+			}
+		})
+	}
+}
+
+func TestLogReadErrorText(t *testing.T) {
+	// the header must still be buffered so logcat can show the message
+	const msg = "logcat: Unable to open log device\n"
+	br := bufio.NewReader(strings.NewReader(msg))
+	if _, err := logRead(br); !errors.Is(err, errLogInvalid) {
+		t.Fatalf("expected invalid header, got %v", err)
+	}
+	if buf, _ := io.ReadAll(br); string(buf) != msg {
+		t.Errorf("expected %q to be unread, got %q", msg, buf)
+	}
+}
+
+func TestLogReadMultiple(t *testing.T) {
+	var in []byte
+	in = append(in, logEntry(24, 0, 0, "\x04a\x00one\x00")...)
+	in = append(in, logEntry(28, 0, 0, "\x04b\x00two\x00")...)
+	br := bufio.NewReader(bytes.NewReader(in))
+	for _, tag := range []string{"a", "b"} {
+		e, err := logRead(br)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Tag != tag {
+			t.Errorf("expected tag %q, got %q", tag, e.Tag)
+		}
+	}
+	if _, err := logRead(br); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,1127 @@
+// Package p9fs implements a 9P2000.L server backed by the filesystem of an ADB
+// device.
+//
+// It can be mounted using the Linux kernel 9p client, e.g.,
+//
+//	mount -t 9p -o trans=tcp,port=5640,version=9p2000.L,aname=/sdcard 127.0.0.1 /mnt
+//
+// The aname, if set, is the directory on the device to use as the root.
+package p9fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math/bits"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+)
+
+// Options contains options for New.
+type Options struct {
+	// ReadOnly rejects all requests which would modify the filesystem.
+	ReadOnly bool
+
+	// MaxMessageSize is the maximum message size to negotiate. If zero, 512 KiB
+	// is used.
+	MaxMessageSize uint32
+}
+
+// Server serves the filesystem of a device over 9P2000.L.
+//
+// Requests are handled concurrently using the connection pool of the FS. Files
+// opened for writing are buffered in a local temporary file, and written to the
+// device when the fid is clunked or fsynced.
+type Server struct {
+	fs    *adbfs.FS
+	ro    bool
+	msize uint32
+}
+
+// New creates a new 9P server for fsys.
+func New(fsys *adbfs.FS, opts *Options) *Server {
+	s := &Server{
+		fs:    fsys,
+		msize: 512 * 1024,
+	}
+	if opts != nil {
+		s.ro = opts.ReadOnly
+		if opts.MaxMessageSize != 0 {
+			s.msize = max(opts.MaxMessageSize, 4096)
+		}
+	}
+	return s
+}
+
+// Serve accepts connections from l, serving each one in a new goroutine. It
+// returns when l.Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection, returning when it is closed or a
+// protocol error occurs. The connection is closed before returning.
+func (s *Server) ServeConn(rw io.ReadWriteCloser) error {
+	c := &conn{
+		s:     s,
+		rw:    rw,
+		msize: s.msize,
+		fids:  make(map[uint32]*fid),
+		tags:  make(map[uint16]*request),
+	}
+	defer rw.Close()
+	defer c.reset()
+
+	for {
+		typ, tag, buf, err := readMsg(rw, c.msize)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch typ {
+		case msgTversion:
+			// no other requests can be outstanding
+			c.reset()
+			e, err := c.version(tag, &decoder{b: buf})
+			c.reply(tag, nil, e, err)
+		case msgTflush:
+			oldtag := (&decoder{b: buf}).u16()
+			r := c.start(tag)
+			c.mu.Lock()
+			old := c.tags[oldtag]
+			if old == r {
+				old = nil // flushing itself
+			} else if old != nil {
+				old.flushed = true
+			}
+			c.mu.Unlock()
+
+			// the old tag can only be reused after the Rflush, so it must not
+			// be sent until the old request is finished
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				if old != nil {
+					<-old.done
+				}
+				c.reply(tag, r, newEncoder(msgRflush, tag), nil)
+			}()
+		default:
+			r := c.start(tag)
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				e, err := c.handle(typ, tag, &decoder{b: buf})
+				c.reply(tag, r, e, err)
+			}()
+		}
+	}
+}
+
+// conn is a client connection.
+type conn struct {
+	s     *Server
+	rw    io.ReadWriteCloser
+	msize uint32 // only changed while no requests are outstanding
+	wg    sync.WaitGroup
+	wmu   sync.Mutex
+
+	mu   sync.Mutex
+	fids map[uint32]*fid
+	tags map[uint16]*request // outstanding
+}
+
+// request is an outstanding request.
+type request struct {
+	flushed bool          // discard the response (protected by conn.mu)
+	done    chan struct{} // closed after the response is sent or discarded
+}
+
+// fid is a file on the device.
+type fid struct {
+	mu    sync.Mutex
+	root  string // attach root
+	name  string
+	open  bool
+	dir   bool
+	de    []fs.DirEntry // snapshot for readdir
+	r     *adbfs.ReaderAt
+	w     *os.File
+	mode  fs.FileMode
+	dirty bool
+}
+
+// reset waits for outstanding requests and clunks all fids.
+func (c *conn) reset() {
+	c.wg.Wait()
+
+	c.mu.Lock()
+	fids := c.fids
+	c.fids = make(map[uint32]*fid)
+	clear(c.tags)
+	c.mu.Unlock()
+
+	for _, f := range fids {
+		c.close(f)
+	}
+}
+
+// start adds an outstanding request for tag.
+func (c *conn) start(tag uint16) *request {
+	r := &request{done: make(chan struct{})}
+	c.mu.Lock()
+	c.tags[tag] = r
+	c.mu.Unlock()
+	return r
+}
+
+// reply sends the response for tag, or an Rlerror if err is not nil, then
+// finishes r if not nil. It is discarded if the request was flushed.
+func (c *conn) reply(tag uint16, r *request, e *encoder, err error) {
+	if err != nil {
+		e = newEncoder(msgRlerror, tag)
+		e.u32(errnoOf(err))
+	}
+
+	var flushed bool
+	if r != nil {
+		c.mu.Lock()
+		flushed = r.flushed
+		if c.tags[tag] == r {
+			delete(c.tags, tag)
+		}
+		c.mu.Unlock()
+		defer close(r.done)
+	}
+
+	if !flushed {
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+
+		c.rw.Write(e.bytes()) // if it fails, the next read will too
+	}
+}
+
+func (c *conn) fid(id uint32) (*fid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.fids[id]
+	if !ok {
+		return nil, errno(eBADF)
+	}
+	return f, nil
+}
+
+func (c *conn) newFid(id uint32, f *fid) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.fids[id]; ok || id == noFid {
+		return errno(eBADF)
+	}
+	c.fids[id] = f
+	return nil
+}
+
+func (c *conn) delFid(id uint32) (*fid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.fids[id]
+	if !ok {
+		return nil, errno(eBADF)
+	}
+	delete(c.fids, id)
+	return f, nil
+}
+
+// renamed updates the fids under oldname after a rename.
+func (c *conn) renamed(oldname, newname string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range c.fids {
+		f.mu.Lock()
+		if f.name == oldname {
+			f.name = newname
+		} else if strings.HasPrefix(f.name, oldname+"/") {
+			f.name = newname + f.name[len(oldname):]
+		}
+		f.mu.Unlock()
+	}
+}
+
+// close closes f, writing it to the device if it was modified.
+func (c *conn) close(f *fid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := c.sync(f)
+	if f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	if f.w != nil {
+		f.w.Close()
+		f.w = nil
+	}
+	f.open, f.de = false, nil
+	return err
+}
+
+// sync writes f to the device if it was modified. It must be called with f.mu
+// held.
+func (c *conn) sync(f *fid) error {
+	if f.w == nil || !f.dirty {
+		return nil
+	}
+	if err := c.s.fs.Send(f.name, io.NewSectionReader(f.w, 0, 1<<63-1), f.mode, time.Time{}); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (c *conn) version(tag uint16, d *decoder) (*encoder, error) {
+	msize, ver := d.u32(), d.str()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if msize < 4096 {
+		return nil, errno(eINVAL)
+	}
+	c.msize = min(msize, c.s.msize)
+	if !strings.HasPrefix(ver, version) {
+		ver = "unknown"
+	} else {
+		ver = version
+	}
+	e := newEncoder(msgRversion, tag)
+	e.u32(c.msize)
+	e.str(ver)
+	return e, nil
+}
+
+func (c *conn) handle(typ uint8, tag uint16, d *decoder) (*encoder, error) {
+	var h func(*encoder, *decoder) error
+	switch typ {
+	case msgTattach:
+		h = c.attach
+	case msgTwalk:
+		h = c.walk
+	case msgTgetattr:
+		h = c.getattr
+	case msgTsetattr:
+		h = c.setattr
+	case msgTstatfs:
+		h = c.statfs
+	case msgTlopen:
+		h = c.lopen
+	case msgTlcreate:
+		h = c.lcreate
+	case msgTread:
+		h = c.read
+	case msgTwrite:
+		h = c.write
+	case msgTreaddir:
+		h = c.readdir
+	case msgTreadlink:
+		h = c.readlink
+	case msgTsymlink:
+		h = c.symlink
+	case msgTmkdir:
+		h = c.mkdir
+	case msgTrename:
+		h = c.rename
+	case msgTrenameat:
+		h = c.renameat
+	case msgTunlinkat:
+		h = c.unlinkat
+	case msgTfsync:
+		h = c.fsync
+	case msgTclunk:
+		h = c.clunk
+	case msgTremove:
+		h = c.remove
+	case msgTlock:
+		h = c.lock
+	case msgTgetlock:
+		h = c.getlock
+	default:
+		// Tauth, Txattrwalk, Txattrcreate, Tmknod, Tlink, and unknown ones
+		return nil, errno(eOPNOTSUPP)
+	}
+	e := newEncoder(typ+1, tag)
+	if err := h(e, d); err != nil {
+		return nil, err
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return e, nil
+}
+
+func (c *conn) attach(e *encoder, d *decoder) error {
+	id, _, _, aname, _ := d.u32(), d.u32(), d.str(), d.str(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	root := strings.TrimPrefix(path.Clean("/"+aname), "/")
+	if root == "" {
+		root = "."
+	}
+	fi, err := c.s.fs.Stat(root)
+	if err != nil {
+		return err
+	}
+	if err := c.newFid(id, &fid{root: root, name: root}); err != nil {
+		return err
+	}
+	e.qid(qidOf(root, fi))
+	return nil
+}
+
+func (c *conn) walk(e *encoder, d *decoder) error {
+	id, newid, n := d.u32(), d.u32(), d.u16()
+	wnames := make([]string, 0, n)
+	for range n {
+		wnames = append(wnames, d.str())
+	}
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	root, name := f.root, f.name
+	f.mu.Unlock()
+
+	names := make([]string, len(wnames))
+	for i, wname := range wnames {
+		switch {
+		case wname == "" || wname == "." || strings.Contains(wname, "/"):
+			return errno(eINVAL)
+		case wname == "..":
+			if name != root {
+				name = path.Dir(name)
+			}
+		default:
+			name = path.Join(name, wname)
+		}
+		names[i] = name
+	}
+
+	fis, errs := c.s.fs.StatMany(names)
+	qids := make([]qid, 0, len(names))
+	for i := range names {
+		if errs[i] != nil {
+			if i == 0 {
+				return errs[i]
+			}
+			break
+		}
+		qids = append(qids, qidOf(names[i], fis[i]))
+	}
+	if len(qids) == len(names) {
+		if newid == id {
+			f.mu.Lock()
+			f.name = name
+			f.mu.Unlock()
+		} else if err := c.newFid(newid, &fid{root: root, name: name}); err != nil {
+			return err
+		}
+	}
+	e.u16(uint16(len(qids)))
+	for _, q := range qids {
+		e.qid(q)
+	}
+	return nil
+}
+
+func (c *conn) getattr(e *encoder, d *decoder) error {
+	id, _ := d.u32(), d.u64()
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := c.s.fs.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	st := fi.Sys().(*adbfs.FileStat)
+	size := st.Size
+	if f.w != nil {
+		if lfi, err := f.w.Stat(); err == nil {
+			size = uint64(lfi.Size())
+		}
+	}
+	e.u64(getattrBasic)
+	e.qid(qidOf(f.name, fi))
+	e.u32(st.Mode)
+	e.u32(st.Uid)
+	e.u32(st.Gid)
+	e.u64(uint64(max(st.Nlink, 1)))
+	e.u64(0) // rdev
+	e.u64(size)
+	e.u64(4096)
+	e.u64((size + 511) / 512)
+	for _, t := range []int64{st.Atime, st.Mtime, st.Ctime, 0} {
+		e.u64(uint64(t))
+		e.u64(0)
+	}
+	e.u64(0) // gen
+	e.u64(0) // data_version
+	return nil
+}
+
+func (c *conn) setattr(e *encoder, d *decoder) error {
+	id, valid, mode, _, _, size := d.u32(), d.u32(), d.u32(), d.u32(), d.u32(), d.u64()
+	atime := time.Unix(int64(d.u64()), int64(d.u64()))
+	mtime := time.Unix(int64(d.u64()), int64(d.u64()))
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	if valid&(setattrUID|setattrGID) != 0 {
+		return errno(ePERM)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if valid&setattrSize != 0 {
+		if f.w != nil {
+			if err := f.w.Truncate(int64(size)); err != nil {
+				return err
+			}
+			f.dirty = true
+		} else if err := c.s.fs.Truncate(f.name, int64(size)); err != nil {
+			return err
+		}
+	}
+	if valid&setattrMode != 0 {
+		if err := c.s.fs.Chmod(f.name, fileMode(mode)); err != nil {
+			return err
+		}
+		if f.w != nil {
+			f.mode = fileMode(mode).Perm()
+		}
+	}
+	if valid&(setattrAtime|setattrMtime) != 0 {
+		now := time.Now()
+		var at, mt time.Time
+		if valid&setattrAtime != 0 {
+			if at = now; valid&setattrAtimeSet != 0 {
+				at = atime
+			}
+		}
+		if valid&setattrMtime != 0 {
+			if mt = now; valid&setattrMtimeSet != 0 {
+				mt = mtime
+			}
+		}
+		if err := c.s.fs.Chtimes(f.name, at, mt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) statfs(e *encoder, d *decoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	st, err := c.s.fs.StatFS(f.name)
+	if err != nil {
+		return err
+	}
+	bs := max(st.BlockSize, 1)
+	e.u32(0x01021997)                // V9FS_MAGIC
+	e.u32(uint32(bs))                // bsize
+	e.u64(uint64(st.Total / bs))     // blocks
+	e.u64(uint64(st.Free / bs))      // bfree
+	e.u64(uint64(st.Available / bs)) // bavail
+	e.u64(uint64(st.Files))          // files
+	e.u64(uint64(st.FilesFree))      // ffree
+	e.u64(0)                         // fsid
+	e.u32(uint32(st.NameMax))        // namelen
+	return nil
+}
+
+func (c *conn) lopen(e *encoder, d *decoder) error {
+	id, flags := d.u32(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.open {
+		return errno(eINVAL)
+	}
+	fi, err := c.s.fs.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.IsDir():
+		if flags&oAccmode != oRdonly {
+			return errno(eISDIR)
+		}
+		f.dir = true
+	case flags&oAccmode == oRdonly:
+		r, err := c.s.fs.Open(f.name)
+		if err != nil {
+			return err
+		}
+		f.r = adbfs.NewReaderAt(r.(io.ReadSeekCloser))
+	default:
+		if c.s.ro {
+			return errno(eROFS)
+		}
+		w, err := c.temp(f.name, flags&oTrunc == 0)
+		if err != nil {
+			return err
+		}
+		f.w, f.mode, f.dirty = w, fi.Mode().Perm(), flags&oTrunc != 0
+	}
+	f.open = true
+	e.qid(qidOf(f.name, fi))
+	e.u32(0) // iounit
+	return nil
+}
+
+func (c *conn) lcreate(e *encoder, d *decoder) error {
+	id, name, flags, mode, _ := d.u32(), d.str(), d.u32(), d.u32(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	if c.s.ro {
+		return errno(eROFS)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.open {
+		return errno(eINVAL)
+	}
+	name, err = child(f.name, name)
+	if err != nil {
+		return err
+	}
+	if flags&oExcl != 0 {
+		if _, err := c.s.fs.Stat(name); err == nil {
+			return errno(eEXIST)
+		}
+	}
+	w, err := c.temp(name, false)
+	if err != nil {
+		return err
+	}
+	if err := c.s.fs.Send(name, w, fileMode(mode).Perm(), time.Time{}); err != nil {
+		w.Close()
+		return err
+	}
+	fi, err := c.s.fs.Stat(name)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	f.name, f.open, f.w, f.mode = name, true, w, fileMode(mode).Perm()
+	e.qid(qidOf(name, fi))
+	e.u32(0) // iounit
+	return nil
+}
+
+// temp creates a temporary file for writing to name, copying the current
+// contents if load is true.
+func (c *conn) temp(name string, load bool) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "adbfs-9p-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmp.Name()) // we only need the fd
+
+	if load {
+		f, err := c.s.fs.Open(name)
+		if err == nil {
+			_, err = io.Copy(tmp, f)
+			f.Close()
+		}
+		if err != nil {
+			tmp.Close()
+			return nil, err
+		}
+	}
+	return tmp, nil
+}
+
+func (c *conn) read(e *encoder, d *decoder) error {
+	id, off, count := d.u32(), d.u64(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dir {
+		return errno(eISDIR)
+	}
+	buf := make([]byte, min(count, c.msize-11))
+	var n int
+	switch {
+	case f.r != nil:
+		n, err = f.r.ReadAt(buf, int64(off))
+		if err == io.EOF {
+			err = nil
+		}
+	case f.w != nil:
+		n, err = f.w.ReadAt(buf, int64(off))
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		return errno(eBADF)
+	}
+	if err != nil {
+		return err
+	}
+	e.u32(uint32(n))
+	e.b = append(e.b, buf[:n]...)
+	return nil
+}
+
+func (c *conn) write(e *encoder, d *decoder) error {
+	id, off, count := d.u32(), d.u64(), d.u32()
+	data := d.next(int(count))
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.w == nil {
+		return errno(eBADF)
+	}
+	n, err := f.w.WriteAt(data, int64(off))
+	if n != 0 {
+		f.dirty = true
+	}
+	if err != nil {
+		return err
+	}
+	e.u32(uint32(n))
+	return nil
+}
+
+func (c *conn) readdir(e *encoder, d *decoder) error {
+	id, off, count := d.u32(), d.u64(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dir {
+		return errno(eNOTDIR)
+	}
+	if off == 0 || f.de == nil {
+		if f.de, err = c.s.fs.ReadDir(f.name); err != nil {
+			return err
+		}
+	}
+
+	count = min(count, c.msize-11)
+	n := len(e.b)
+	e.u32(0)
+	for i := off; i < uint64(len(f.de)); i++ {
+		de := f.de[i]
+		if len(e.b)-n-4+13+8+1+2+len(de.Name()) > int(count) {
+			break
+		}
+		name := path.Join(f.name, de.Name())
+		if fi, err := de.Info(); err == nil {
+			e.qid(qidOf(name, fi))
+		} else {
+			e.qid(qid{Path: pathHash(name)})
+		}
+		e.u64(i + 1)
+		e.u8(direntType(de.Type()))
+		e.str(de.Name())
+	}
+	binary.LittleEndian.PutUint32(e.b[n:], uint32(len(e.b)-n-4))
+	return nil
+}
+
+func (c *conn) readlink(e *encoder, d *decoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	target, err := c.s.fs.Readlink(f.path())
+	if err != nil {
+		return err
+	}
+	e.str(target)
+	return nil
+}
+
+func (c *conn) symlink(e *encoder, d *decoder) error {
+	id, name, target, _ := d.u32(), d.str(), d.str(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	name, err = child(f.path(), name)
+	if err != nil {
+		return err
+	}
+	if err := c.s.fs.Symlink(target, name); err != nil {
+		return err
+	}
+	return c.qid(e, name)
+}
+
+func (c *conn) mkdir(e *encoder, d *decoder) error {
+	id, name, mode, _ := d.u32(), d.str(), d.u32(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	name, err = child(f.path(), name)
+	if err != nil {
+		return err
+	}
+	if err := c.s.fs.Mkdir(name, fileMode(mode)); err != nil {
+		return err
+	}
+	return c.qid(e, name)
+}
+
+func (c *conn) rename(e *encoder, d *decoder) error {
+	id, did, name := d.u32(), d.u32(), d.str()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	df, err := c.fid(did)
+	if err != nil {
+		return err
+	}
+	newname, err := child(df.path(), name)
+	if err != nil {
+		return err
+	}
+	return c.doRename(f.path(), newname)
+}
+
+func (c *conn) renameat(e *encoder, d *decoder) error {
+	oid, oldname, nid, newname := d.u32(), d.str(), d.u32(), d.str()
+	if d.err != nil {
+		return d.err
+	}
+	of, err := c.fid(oid)
+	if err != nil {
+		return err
+	}
+	nf, err := c.fid(nid)
+	if err != nil {
+		return err
+	}
+	if oldname, err = child(of.path(), oldname); err != nil {
+		return err
+	}
+	if newname, err = child(nf.path(), newname); err != nil {
+		return err
+	}
+	return c.doRename(oldname, newname)
+}
+
+func (c *conn) doRename(oldname, newname string) error {
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	if err := c.s.fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	c.renamed(oldname, newname)
+	return nil
+}
+
+func (c *conn) unlinkat(e *encoder, d *decoder) error {
+	id, name, flags := d.u32(), d.str(), d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	if name, err = child(f.path(), name); err != nil {
+		return err
+	}
+	fi, err := c.s.fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if flags&atRemovedir != 0 && !fi.IsDir() {
+		return errno(eNOTDIR)
+	}
+	if flags&atRemovedir == 0 && fi.IsDir() {
+		return errno(eISDIR)
+	}
+	return c.s.fs.Remove(name)
+}
+
+func (c *conn) fsync(e *encoder, d *decoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return c.sync(f)
+}
+
+func (c *conn) clunk(e *encoder, d *decoder) error {
+	f, err := c.delFid(d.u32())
+	if err != nil {
+		return err
+	}
+	return c.close(f)
+}
+
+func (c *conn) remove(e *encoder, d *decoder) error {
+	f, err := c.delFid(d.u32())
+	if err != nil {
+		return err
+	}
+	c.close(f)
+	if c.s.ro {
+		return errno(eROFS)
+	}
+	return c.s.fs.Remove(f.path())
+}
+
+func (c *conn) lock(e *encoder, d *decoder) error {
+	// locks are only local to the client
+	if _, err := c.fid(d.u32()); err != nil {
+		return err
+	}
+	e.u8(0) // P9_LOCK_SUCCESS
+	return nil
+}
+
+func (c *conn) getlock(e *encoder, d *decoder) error {
+	id, _, start, length, procID, clientID := d.u32(), d.u8(), d.u64(), d.u64(), d.u32(), d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.fid(id); err != nil {
+		return err
+	}
+	e.u8(2) // F_UNLCK
+	e.u64(start)
+	e.u64(length)
+	e.u32(procID)
+	e.str(clientID)
+	return nil
+}
+
+// qid stats name, adding the qid to e.
+func (c *conn) qid(e *encoder, name string) error {
+	fi, err := c.s.fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	e.qid(qidOf(name, fi))
+	return nil
+}
+
+func (f *fid) path() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.name
+}
+
+// child returns the path of name in dir.
+func child(dir, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", errno(eINVAL)
+	}
+	return path.Join(dir, name), nil
+}
+
+// qidOf returns the qid for name. If the device doesn't provide inode numbers,
+// a hash of the path is used.
+func qidOf(name string, fi fs.FileInfo) qid {
+	st := fi.Sys().(*adbfs.FileStat)
+	q := qid{
+		Version: uint32(st.Mtime),
+		Path:    st.Ino ^ bits.RotateLeft64(st.Dev, 32),
+	}
+	if st.Ino == 0 {
+		q.Path = pathHash(name)
+	}
+	switch {
+	case fi.IsDir():
+		q.Type = qtDir
+	case fi.Mode()&fs.ModeSymlink != 0:
+		q.Type = qtSymlink
+	default:
+		q.Type = qtFile
+	}
+	return q
+}
+
+func pathHash(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+func direntType(m fs.FileMode) uint8 {
+	switch {
+	case m&fs.ModeDir != 0:
+		return dtDir
+	case m&fs.ModeSymlink != 0:
+		return dtLnk
+	case m&fs.ModeNamedPipe != 0:
+		return dtFifo
+	case m&fs.ModeSocket != 0:
+		return dtSock
+	case m&fs.ModeCharDevice != 0:
+		return dtChr
+	case m&fs.ModeDevice != 0:
+		return dtBlk
+	}
+	return dtReg
+}
+
+// fileMode converts the permission, setuid, setgid, and sticky bits from a
+// linux mode.
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// errno is a linux errno.
+type errno uint32
+
+func (e errno) Error() string {
+	return "errno " + strconv.FormatUint(uint64(e), 10)
+}
+
+// errnoOf converts err into a linux errno.
+func errnoOf(err error) uint32 {
+	var e errno
+	if errors.As(err, &e) {
+		return uint32(e)
+	}
+	var de *adbfs.Error
+	if errors.As(err, &de) && de.Errno != 0 {
+		return de.Errno
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return eNOENT
+	case errors.Is(err, fs.ErrExist):
+		return eEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return eACCES
+	case errors.Is(err, adbfs.ErrNotDirectory):
+		return eNOTDIR
+	case errors.Is(err, adbfs.ErrIsDirectory):
+		return eISDIR
+	case errors.Is(err, fs.ErrInvalid):
+		return eINVAL
+	}
+	return eIO
+}
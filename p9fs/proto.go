@@ -0,0 +1,211 @@
+package p9fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// https://github.com/chaos/diod/blob/master/protocol.md
+// https://github.com/torvalds/linux/blob/master/include/net/9p/9p.h
+
+const version = "9P2000.L"
+
+// message types
+const (
+	msgRlerror      = 7
+	msgTstatfs      = 8
+	msgRstatfs      = 9
+	msgTlopen       = 12
+	msgRlopen       = 13
+	msgTlcreate     = 14
+	msgRlcreate     = 15
+	msgTsymlink     = 16
+	msgRsymlink     = 17
+	msgTmknod       = 18
+	msgRmknod       = 19
+	msgTrename      = 20
+	msgRrename      = 21
+	msgTreadlink    = 22
+	msgRreadlink    = 23
+	msgTgetattr     = 24
+	msgRgetattr     = 25
+	msgTsetattr     = 26
+	msgRsetattr     = 27
+	msgTxattrwalk   = 30
+	msgRxattrwalk   = 31
+	msgTxattrcreate = 32
+	msgRxattrcreate = 33
+	msgTreaddir     = 40
+	msgRreaddir     = 41
+	msgTfsync       = 50
+	msgRfsync       = 51
+	msgTlock        = 52
+	msgRlock        = 53
+	msgTgetlock     = 54
+	msgRgetlock     = 55
+	msgTlink        = 70
+	msgRlink        = 71
+	msgTmkdir       = 72
+	msgRmkdir       = 73
+	msgTrenameat    = 74
+	msgRrenameat    = 75
+	msgTunlinkat    = 76
+	msgRunlinkat    = 77
+	msgTversion     = 100
+	msgRversion     = 101
+	msgTauth        = 102
+	msgRauth        = 103
+	msgTattach      = 104
+	msgRattach      = 105
+	msgTflush       = 108
+	msgRflush       = 109
+	msgTwalk        = 110
+	msgRwalk        = 111
+	msgTread        = 116
+	msgRread        = 117
+	msgTwrite       = 118
+	msgRwrite       = 119
+	msgTclunk       = 120
+	msgRclunk       = 121
+	msgTremove      = 122
+	msgRremove      = 123
+)
+
+// qid types
+const (
+	qtDir     = 0x80
+	qtSymlink = 0x02
+	qtFile    = 0x00
+)
+
+// getattr/setattr masks
+const (
+	getattrBasic = 0x000007ff
+
+	setattrMode     = 0x00000001
+	setattrUID      = 0x00000002
+	setattrGID      = 0x00000004
+	setattrSize     = 0x00000008
+	setattrAtime    = 0x00000010
+	setattrMtime    = 0x00000020
+	setattrCtime    = 0x00000040
+	setattrAtimeSet = 0x00000080
+	setattrMtimeSet = 0x00000100
+)
+
+// linux open flags
+const (
+	oAccmode    = 0x3
+	oRdonly     = 0x0
+	oTrunc      = 0x200
+	oExcl       = 0x80
+	atRemovedir = 0x200
+)
+
+// linux errnos
+const (
+	ePERM      = 1
+	eNOENT     = 2
+	eIO        = 5
+	eBADF      = 9
+	eEXIST     = 17
+	eACCES     = 13
+	eNOTDIR    = 20
+	eISDIR     = 21
+	eINVAL     = 22
+	eROFS      = 30
+	eNOSYS     = 38
+	eNOTEMPTY  = 39
+	eOPNOTSUPP = 95
+)
+
+// linux dirent types
+const (
+	dtFifo = 1
+	dtChr  = 2
+	dtDir  = 4
+	dtBlk  = 6
+	dtReg  = 8
+	dtLnk  = 10
+	dtSock = 12
+)
+
+const noFid = ^uint32(0)
+
+type qid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+var errMessage = errors.New("malformed message")
+
+// decoder reads fields from a message.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = errMessage
+		return make([]byte, n)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8   { return d.next(1)[0] }
+func (d *decoder) u16() uint16 { return binary.LittleEndian.Uint16(d.next(2)) }
+func (d *decoder) u32() uint32 { return binary.LittleEndian.Uint32(d.next(4)) }
+func (d *decoder) u64() uint64 { return binary.LittleEndian.Uint64(d.next(8)) }
+func (d *decoder) str() string { return string(d.next(int(d.u16()))) }
+
+// encoder builds a message.
+type encoder struct {
+	b []byte
+}
+
+func newEncoder(typ uint8, tag uint16) *encoder {
+	e := &encoder{b: make([]byte, 4, 64)}
+	e.u8(typ)
+	e.u16(tag)
+	return e
+}
+
+func (e *encoder) u8(v uint8)   { e.b = append(e.b, v) }
+func (e *encoder) u16(v uint16) { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *encoder) u32(v uint32) { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *encoder) u64(v uint64) { e.b = binary.LittleEndian.AppendUint64(e.b, v) }
+func (e *encoder) str(v string) { e.u16(uint16(len(v))); e.b = append(e.b, v...) }
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.Type)
+	e.u32(q.Version)
+	e.u64(q.Path)
+}
+
+// bytes returns the message with the size set.
+func (e *encoder) bytes() []byte {
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	return e.b
+}
+
+// readMsg reads a message, returning the type, tag, and body.
+func readMsg(r io.Reader, msize uint32) (uint8, uint16, []byte, error) {
+	var hdr [7]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[:4])
+	if size < 7 || size > msize {
+		return 0, 0, nil, errMessage
+	}
+	buf := make([]byte, size-7)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, 0, nil, err
+	}
+	return hdr[4], binary.LittleEndian.Uint16(hdr[5:]), buf, nil
+}
@@ -0,0 +1,159 @@
+package adbfs_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+	"github.com/pgaskin/go-adbfs/p9fs"
+)
+
+// p9msg builds a 9P message.
+type p9msg []byte
+
+func (m p9msg) u16(v uint16) p9msg { return binary.LittleEndian.AppendUint16(m, v) }
+func (m p9msg) u32(v uint32) p9msg { return binary.LittleEndian.AppendUint32(m, v) }
+func (m p9msg) u64(v uint64) p9msg { return binary.LittleEndian.AppendUint64(m, v) }
+func (m p9msg) str(v string) p9msg { return append(m.u16(uint16(len(v))), v...) }
+
+func TestP9FS(t *testing.T) {
+	fsys, dev, dir := adbfs.NewTestFS(t, "")
+	if err := os.WriteFile("/"+dir+"/a.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("/"+dir+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	block, blocked := make(chan struct{}), make(chan struct{}, 1)
+	unblock := sync.OnceFunc(func() { close(block) })
+	defer unblock()
+	dev.SetHook(func(id, name string) {
+		if id == "RECV" && strings.HasSuffix(name, "/a.txt") {
+			select {
+			case blocked <- struct{}{}:
+				<-block
+			default:
+			}
+		}
+	})
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go p9fs.New(fsys, nil).ServeConn(sc)
+
+	send := func(typ uint8, tag uint16, body p9msg) {
+		t.Helper()
+		m := binary.LittleEndian.AppendUint32(nil, uint32(7+len(body)))
+		m = append(append(m, typ), byte(tag), byte(tag>>8))
+		cc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := cc.Write(append(m, body...)); err != nil {
+			t.Fatalf("send %d: %v", typ, err)
+		}
+	}
+	recv := func(timeout time.Duration) (uint8, uint16, []byte, error) {
+		t.Helper()
+		cc.SetReadDeadline(time.Now().Add(timeout))
+		var hdr [7]byte
+		if _, err := io.ReadFull(cc, hdr[:]); err != nil {
+			return 0, 0, nil, err
+		}
+		buf := make([]byte, binary.LittleEndian.Uint32(hdr[:])-7)
+		if _, err := io.ReadFull(cc, buf); err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		return hdr[4], binary.LittleEndian.Uint16(hdr[5:]), buf, nil
+	}
+	call := func(typ uint8, tag uint16, body p9msg) []byte {
+		t.Helper()
+		send(typ, tag, body)
+		rtyp, rtag, buf, err := recv(5 * time.Second)
+		if err != nil {
+			t.Fatalf("recv %d: %v", typ, err)
+		}
+		if rtag != tag {
+			t.Fatalf("recv %d: expected tag %d, got %d", typ, tag, rtag)
+		}
+		if rtyp == 7 { // Rlerror
+			t.Fatalf("recv %d: error %d", typ, binary.LittleEndian.Uint32(buf))
+		}
+		if rtyp != typ+1 {
+			t.Fatalf("recv %d: expected type %d, got %d", typ, typ+1, rtyp)
+		}
+		return buf
+	}
+
+	if buf := call(100, 0xFFFF, p9msg{}.u32(8192).str("9P2000.L")); string(buf[6:]) != "9P2000.L" {
+		t.Fatalf("version: got %q", buf[6:])
+	}
+	call(104, 1, p9msg{}.u32(0).u32(^uint32(0)).str("").str("/"+dir).u32(0)) // attach
+	call(110, 1, p9msg{}.u32(0).u32(1).u16(0))                               // walk to a clone
+	if buf := call(110, 1, p9msg{}.u32(0).u32(2).u16(1).str("a.txt")); binary.LittleEndian.Uint16(buf) != 1 {
+		t.Fatalf("walk: expected 1 qid, got %d", binary.LittleEndian.Uint16(buf))
+	}
+
+	// readdir
+	call(12, 1, p9msg{}.u32(1).u32(0)) // lopen
+	var names []string
+	for buf := call(40, 1, p9msg{}.u32(1).u64(0).u32(8192))[4:]; len(buf) != 0; {
+		n := int(binary.LittleEndian.Uint16(buf[22:]))
+		names = append(names, string(buf[24:24+n]))
+		buf = buf[24+n:]
+	}
+	if exp := []string{"a.txt", "sub"}; !slices.Equal(names, exp) {
+		t.Errorf("readdir: expected %q, got %q", exp, names)
+	}
+	call(120, 1, p9msg{}.u32(1)) // clunk
+
+	// read
+	call(12, 1, p9msg{}.u32(2).u32(0)) // lopen
+	send(116, 5, p9msg{}.u32(2).u64(6).u32(5))
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("read: not started")
+	}
+
+	// flush, which must not be answered until the read is done
+	send(108, 6, p9msg{}.u16(5))
+	if _, _, _, err := recv(100 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("flush: expected no response while the read is blocked, got %v", err)
+	}
+	unblock()
+	for flushed := false; !flushed; {
+		typ, tag, _, err := recv(5 * time.Second)
+		switch {
+		case err != nil:
+			t.Fatalf("flush: %v", err)
+		case typ == 109 && tag == 6:
+			flushed = true
+		case typ == 117 && tag == 5:
+			// the response may be sent before the Rflush
+		default:
+			t.Fatalf("flush: unexpected response %d with tag %d", typ, tag)
+		}
+	}
+
+	// the tag can be reused now
+	if buf := call(116, 5, p9msg{}.u32(2).u64(6).u32(5)); string(buf[4:]) != "world" {
+		t.Errorf("read: expected %q, got %q", "world", buf[4:])
+	}
+	if buf := call(116, 5, p9msg{}.u32(2).u64(0).u32(64)); string(buf[4:]) != "hello world" {
+		t.Errorf("read: expected %q, got %q", "hello world", buf[4:])
+	}
+	if buf := call(116, 5, p9msg{}.u32(2).u64(64).u32(64)); len(buf[4:]) != 0 {
+		t.Errorf("read: expected eof, got %q", buf[4:])
+	}
+	call(120, 1, p9msg{}.u32(2)) // clunk
+	if _, _, _, err := recv(100 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unexpected response after flushed request: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package adbfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstallOptions contains options for Install.
+type InstallOptions struct {
+	Replace          bool     // replace an existing app (-r)
+	Downgrade        bool     // allow a lower version code (-d)
+	GrantPermissions bool     // grant all runtime permissions (-g)
+	Test             bool     // allow test packages (-t)
+	User             string   // user to install for, if not empty (--user)
+	Args             []string // additional pm arguments
+}
+
+func (o *InstallOptions) args() string {
+	var args []string
+	if o != nil {
+		if o.Replace {
+			args = append(args, "-r")
+		}
+		if o.Downgrade {
+			args = append(args, "-d")
+		}
+		if o.GrantPermissions {
+			args = append(args, "-g")
+		}
+		if o.Test {
+			args = append(args, "-t")
+		}
+		if o.User != "" {
+			args = append(args, "--user", o.User)
+		}
+		args = append(args, o.Args...)
+	}
+	for i, arg := range args {
+		args[i] = " " + shellQuote(arg)
+	}
+	return strings.Join(args, "")
+}
+
+// Install installs an app from one or more APKs. If there are multiple APKs
+// (i.e., split APKs), they are installed together in a single session.
+//
+// If the device supports it, the APKs are streamed to the package manager.
+// Otherwise, they are pushed to /data/local/tmp, installed with pm, then
+// removed.
+func (c *FS) Install(ctx context.Context, apks []fs.File, opts *InstallOptions) error {
+	if len(apks) == 0 {
+		return errors.New("install: no apks")
+	}
+	fis := make([]fs.FileInfo, len(apks))
+	for i, f := range apks {
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("install: %w", err)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("install: %s: not a regular file", fi.Name())
+		}
+		fis[i] = fi
+	}
+	if !c.hasFeature("cmd") {
+		return c.installLegacy(ctx, apks, fis, opts)
+	}
+	if len(apks) == 1 {
+		buf, err := c.exec(ctx, "cmd package install"+opts.args()+" -S "+strconv.FormatInt(fis[0].Size(), 10), apks[0])
+		if err != nil {
+			return fmt.Errorf("install: %w", err)
+		}
+		return pmResult("install", buf)
+	}
+
+	var size int64
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+	session, err := c.installCreate(ctx, "cmd package", opts.args()+" -S "+strconv.FormatInt(size, 10))
+	if err != nil {
+		return err
+	}
+	for i, f := range apks {
+		buf, err := c.exec(ctx, "cmd package install-write -S "+strconv.FormatInt(fis[i].Size(), 10)+" "+session+" "+strconv.Itoa(i)+"_"+shellQuote(fis[i].Name())+" -", f)
+		if err == nil {
+			err = pmResult("install-write", buf)
+		}
+		if err != nil {
+			c.exec(context.Background(), "cmd package install-abandon "+session, nil)
+			return err
+		}
+	}
+	buf, err := c.exec(ctx, "cmd package install-commit "+session, nil)
+	if err != nil {
+		return fmt.Errorf("install-commit: %w", err)
+	}
+	return pmResult("install-commit", buf)
+}
+
+// InstallFiles is like Install, but opens the named local APKs.
+func (c *FS) InstallFiles(ctx context.Context, names []string, opts *InstallOptions) error {
+	apks := make([]fs.File, 0, len(names))
+	defer func() {
+		for _, f := range apks {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("install: %w", err)
+		}
+		apks = append(apks, f)
+	}
+	return c.Install(ctx, apks, opts)
+}
+
+// installLegacy installs APKs by pushing them to the device first, for
+// devices without the cmd command.
+func (c *FS) installLegacy(ctx context.Context, apks []fs.File, fis []fs.FileInfo, opts *InstallOptions) error {
+	names := make([]string, len(apks))
+	defer func() {
+		for _, name := range names {
+			if name != "" {
+				c.Remove(name)
+			}
+		}
+	}()
+	for i, f := range apks {
+		name := fmt.Sprintf("data/local/tmp/adbfs-%d-%d.apk", time.Now().UnixNano(), i)
+		if err := c.Send(name, &ctxReader{ctx, f}, 0644, time.Time{}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("install: %w", err)
+		}
+		names[i] = name
+	}
+	if len(names) == 1 {
+		buf, err := c.shell(ctx, "pm install"+opts.args()+" "+shellQuote("/"+names[0])+" 2>&1", nil)
+		if err != nil && !isExitError(err) {
+			return fmt.Errorf("install: %w", err)
+		}
+		return pmResult("install", buf)
+	}
+
+	var size int64
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+	session, err := c.installCreate(ctx, "pm", opts.args()+" -S "+strconv.FormatInt(size, 10))
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		buf, err := c.shell(ctx, "pm install-write -S "+strconv.FormatInt(fis[i].Size(), 10)+" "+session+" "+strconv.Itoa(i)+"_"+shellQuote(fis[i].Name())+" "+shellQuote("/"+name)+" 2>&1", nil)
+		if err == nil || isExitError(err) {
+			err = pmResult("install-write", buf)
+		}
+		if err != nil {
+			c.shell(context.Background(), "pm install-abandon "+session, nil)
+			return err
+		}
+	}
+	buf, err := c.shell(ctx, "pm install-commit "+session+" 2>&1", nil)
+	if err != nil && !isExitError(err) {
+		return fmt.Errorf("install-commit: %w", err)
+	}
+	return pmResult("install-commit", buf)
+}
+
+var installSessionRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// installCreate creates an install session using pm, returning the session ID.
+func (c *FS) installCreate(ctx context.Context, pm, args string) (string, error) {
+	buf, err := c.exec(ctx, pm+" install-create"+args, nil)
+	if err != nil {
+		return "", fmt.Errorf("install-create: %w", err)
+	}
+	if err := pmResult("install-create", buf); err != nil {
+		return "", err
+	}
+	m := installSessionRe.FindSubmatch(buf)
+	if m == nil {
+		return "", fmt.Errorf("install-create: missing session id in %q", strings.TrimSpace(string(buf)))
+	}
+	return string(m[1]), nil
+}
+
+// Uninstall uninstalls a package, optionally keeping the data and cache
+// directories.
+func (c *FS) Uninstall(ctx context.Context, pkg string, keepData bool) error {
+	cmd := "pm uninstall "
+	if keepData {
+		cmd += "-k "
+	}
+	buf, err := c.shell(ctx, cmd+shellQuote(pkg)+" 2>&1", nil)
+	if err != nil && !isExitError(err) {
+		return fmt.Errorf("uninstall: %w", err)
+	}
+	return pmResult("uninstall", buf)
+}
+
+// Package is an installed package.
+type Package struct {
+	Name        string // package name
+	Path        string // path to the base APK
+	UID         int    // app user ID, or -1 if unknown
+	VersionCode int64  // version code, or -1 if unknown
+	Installer   string // package name of the installer, if known
+}
+
+// ListPackagesOptions contains options for ListPackages.
+type ListPackagesOptions struct {
+	System     bool   // only list system packages (-s)
+	ThirdParty bool   // only list third-party packages (-3)
+	Enabled    bool   // only list enabled packages (-e)
+	Disabled   bool   // only list disabled packages (-d)
+	User       string // user to list packages for, if not empty (--user)
+}
+
+// ListPackages lists installed packages. The version code and UID are only
+// available on Android 8.0 or later.
+func (c *FS) ListPackages(ctx context.Context, opts *ListPackagesOptions) ([]Package, error) {
+	var args string
+	if opts != nil {
+		if opts.System {
+			args += " -s"
+		}
+		if opts.ThirdParty {
+			args += " -3"
+		}
+		if opts.Enabled {
+			args += " -e"
+		}
+		if opts.Disabled {
+			args += " -d"
+		}
+		if opts.User != "" {
+			args += " --user " + shellQuote(opts.User)
+		}
+	}
+	buf, err := c.shell(ctx, "pm list packages -f -i -U --show-versioncode"+args+" 2>/dev/null || pm list packages -f -i"+args, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list packages: %w", err)
+	}
+	return parsePackages(buf), nil
+}
+
+// parsePackages parses the output of pm list packages -f -i -U
+// --show-versioncode, which looks like:
+//
+//	package:/data/app/~~abc==/com.example-def==/base.apk=com.example versionCode:1 uid:10123 installer=com.android.vending
+func parsePackages(buf []byte) []Package {
+	var pkgs []Package
+	for _, line := range strings.Split(string(buf), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		s, ok := strings.CutPrefix(f[0], "package:")
+		if !ok {
+			continue
+		}
+		pkg := Package{UID: -1, VersionCode: -1}
+		if i := strings.LastIndexByte(s, '='); i != -1 {
+			pkg.Path, pkg.Name = s[:i], s[i+1:]
+		} else {
+			pkg.Name = s
+		}
+		for _, v := range f[1:] {
+			switch k, v, _ := strings.Cut(v, ":"); k {
+			case "versionCode":
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+					pkg.VersionCode = n
+				}
+			case "uid":
+				// shared uids are listed as uid:10123,1000
+				v, _, _ = strings.Cut(v, ",")
+				if n, err := strconv.Atoi(v); err == nil {
+					pkg.UID = n
+				}
+			}
+			if v, ok := strings.CutPrefix(v, "installer="); ok && v != "null" {
+				pkg.Installer = v
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// pmResult returns an error if the pm output doesn't indicate success.
+func pmResult(op string, buf []byte) error {
+	var msg string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Success") {
+			return nil
+		}
+		if strings.HasPrefix(line, "Failure") || strings.HasPrefix(line, "Error") {
+			msg = line
+		}
+	}
+	if msg == "" {
+		if msg = shellLastLine(buf); msg == "" {
+			msg = "no output"
+		}
+	}
+	return fmt.Errorf("%s: %s", op, msg)
+}
+
+// exec runs cmd using the exec service, returning the output (including
+// stderr). If stdin is not nil, it is copied to the command's stdin.
+func (c *FS) exec(ctx context.Context, cmd string, stdin io.Reader) ([]byte, error) {
+	conn, err := adbConnectDeviceContext(ctx, c.addr, c.serial, "exec:"+cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	if stdin != nil {
+		// don't return while stdin is still being read
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			io.Copy(conn, stdin)
+		}()
+		defer func() {
+			conn.Close()
+			<-done
+		}()
+	}
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("exec %q: %w", cmd, err)
+	}
+	return buf, nil
+}
+
+func isExitError(err error) bool {
+	_, ok := err.(*ExitError)
+	return ok
+}
@@ -0,0 +1,54 @@
+package adbfs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePackages(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		pkgs []Package
+	}{
+		{
+			name: "Full",
+			out:  "package:/data/app/~~abc==/com.example-def==/base.apk=com.example versionCode:12 uid:10123 installer=com.android.vending\n",
+			pkgs: []Package{{Name: "com.example", Path: "/data/app/~~abc==/com.example-def==/base.apk", UID: 10123, VersionCode: 12, Installer: "com.android.vending"}},
+		},
+		{
+			name: "SharedUID",
+			out:  "package:/system/framework/framework-res.apk=android versionCode:34 uid:1000,1001 installer=null\n",
+			pkgs: []Package{{Name: "android", Path: "/system/framework/framework-res.apk", UID: 1000, VersionCode: 34}},
+		},
+		{
+			name: "Legacy",
+			out:  "package:/data/app/com.example-1/base.apk=com.example  installer=null\r\npackage:/system/app/Foo.apk=com.foo  installer=com.android.vending\r\n",
+			pkgs: []Package{
+				{Name: "com.example", Path: "/data/app/com.example-1/base.apk", UID: -1, VersionCode: -1},
+				{Name: "com.foo", Path: "/system/app/Foo.apk", UID: -1, VersionCode: -1, Installer: "com.android.vending"},
+			},
+		},
+		{
+			name: "NoPath",
+			out:  "package:com.example\n",
+			pkgs: []Package{{Name: "com.example", UID: -1, VersionCode: -1}},
+		},
+		{
+			name: "Junk",
+			out:  "\nWARNING: linker: something\npackage:com.example\n\n",
+			pkgs: []Package{{Name: "com.example", UID: -1, VersionCode: -1}},
+		},
+		{
+			name: "Empty",
+			out:  "",
+			pkgs: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if pkgs := parsePackages([]byte(tc.out)); !slices.Equal(pkgs, tc.pkgs) {
+				t.Errorf("expected %+v, got %+v", tc.pkgs, pkgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package adbfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net"
+)
+
+// syncPipelineMax is the maximum number of requests written to a sync
+// connection before reading the responses. This keeps the requests small
+// enough to fit in the socket buffers so neither side blocks writing while the
+// other is still writing.
+const syncPipelineMax = 64
+
+// StatMany is like Stat, but for multiple files. The requests are pipelined on
+// a single connection, so it is much faster than calling Stat for each file on
+// a high-latency connection.
+//
+// The returned slices have the same length as names. For each name, either the
+// file info or the error will be set.
+func (c *FS) StatMany(names []string) ([]fs.FileInfo, []error) {
+	fi := make([]fs.FileInfo, len(names))
+	errs := make([]error, len(names))
+
+	idx := fsPipelineIndex(names, errs)
+	if len(idx) == 0 {
+		return fi, errs
+	}
+
+	conn, err := c.getConn()
+	if err != nil {
+		for _, i := range idx {
+			errs[i] = err
+		}
+		return fi, errs
+	}
+
+	if !fsStatMany(conn, names, idx, fi, errs, c.fsFeat()) {
+		c.delConn(conn)
+	} else {
+		c.putConn(conn)
+	}
+	return fi, errs
+}
+
+// ReadDirMany is like ReadDir, but for multiple directories. The requests are
+// pipelined on a single connection, so it is much faster than calling ReadDir
+// for each directory on a high-latency connection.
+//
+// The returned slices have the same length as names. For each name, either the
+// entries or the error will be set.
+func (c *FS) ReadDirMany(names []string) ([][]fs.DirEntry, []error) {
+	de := make([][]fs.DirEntry, len(names))
+	errs := make([]error, len(names))
+
+	idx := fsPipelineIndex(names, errs)
+	if len(idx) == 0 {
+		return de, errs
+	}
+
+	conn, err := c.getConn()
+	if err != nil {
+		for _, i := range idx {
+			errs[i] = err
+		}
+		return de, errs
+	}
+
+	if !fsReadDirMany(conn, names, idx, de, errs, c.fsFeat()) {
+		c.delConn(conn)
+	} else {
+		c.putConn(conn)
+	}
+	return de, errs
+}
+
+// fsPipelineIndex returns the indexes of the valid names, setting errs for the
+// invalid ones.
+func fsPipelineIndex(names []string, errs []error) []int {
+	idx := make([]int, 0, len(names))
+	for i, name := range names {
+		if !fs.ValidPath(name) {
+			errs[i] = &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  fs.ErrInvalid,
+			}
+			continue
+		}
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// fsStatMany pipelines stat requests for names[idx...] on conn. If false is
+// returned, conn is no longer usable.
+func fsStatMany(conn net.Conn, names []string, idx []int, fi []fs.FileInfo, errs []error, feat fsFeat) bool {
+	return fsPipeline(conn, names, idx, errs, func(w io.Writer, name string) error {
+		return fsStatRequest(w, name, feat)
+	}, func(i int) bool {
+		st, err := fsStatResponse(conn, names[i], feat)
+		if err != nil {
+			errs[i] = err
+			return false
+		}
+		fi[i], errs[i] = fsStatResult(names[i], st, feat)
+		return true
+	})
+}
+
+// fsReadDirMany pipelines readdir requests for names[idx...] on conn. If false
+// is returned, conn is no longer usable.
+func fsReadDirMany(conn net.Conn, names []string, idx []int, de [][]fs.DirEntry, errs []error, feat fsFeat) bool {
+	var check []int
+	if !fsPipeline(conn, names, idx, errs, func(w io.Writer, name string) error {
+		return fsReadDirRequest(w, name, feat)
+	}, func(i int) bool {
+		var seen bool
+		de[i], seen, errs[i] = fsReadDirResponse(conn, names[i], feat)
+		if errs[i] != nil {
+			return false
+		}
+		if !seen {
+			check = append(check, i)
+		}
+		return true
+	}) {
+		return false
+	}
+	if len(check) != 0 {
+		fi := make([]fs.FileInfo, len(names))
+		ok := fsStatMany(conn, names, check, fi, errs, feat)
+		for _, i := range check {
+			if errs[i] = fsReadDirCheck(names[i], fi[i], errs[i]); errs[i] != nil {
+				de[i] = nil
+			}
+		}
+		return ok
+	}
+	return true
+}
+
+// fsPipeline writes the requests for names[idx...] to conn in batches of at
+// most syncPipelineMax, reading the responses for each batch in order before
+// writing the next. If resp returns false, the connection is assumed to be in
+// a bad state, the error for the remaining names is set to the one for the
+// failed name, and false is returned.
+func fsPipeline(conn net.Conn, names []string, idx []int, errs []error, req func(io.Writer, string) error, resp func(int) bool) bool {
+	var buf bytes.Buffer
+	for len(idx) != 0 {
+		batch := idx[:min(len(idx), syncPipelineMax)]
+		idx = idx[len(batch):]
+
+		buf.Reset()
+		for _, i := range batch {
+			req(&buf, names[i]) // bytes.Buffer writes don't fail
+		}
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			fsPipelineFail(names, batch, errs, err)
+			fsPipelineFail(names, idx, errs, err)
+			return false
+		}
+		for j, i := range batch {
+			if !resp(i) {
+				err := errs[i]
+				if pe, ok := err.(*fs.PathError); ok {
+					err = pe.Err
+				}
+				fsPipelineFail(names, batch[j+1:], errs, err)
+				fsPipelineFail(names, idx, errs, err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func fsPipelineFail(names []string, idx []int, errs []error, err error) {
+	for _, i := range idx {
+		errs[i] = &fs.PathError{
+			Op:   "pipeline",
+			Path: names[i],
+			Err:  err,
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package adbfs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Properties returns all system properties using getprop. Read-only (ro.*)
+// properties are cached for GetProp.
+func (c *FS) Properties(ctx context.Context) (map[string]string, error) {
+	buf, err := c.shell(ctx, "getprop", nil)
+	if err != nil {
+		return nil, fmt.Errorf("getprop: %w", err)
+	}
+	props := parseProperties(string(buf))
+
+	c.propMu.Lock()
+	defer c.propMu.Unlock()
+
+	for name, value := range props {
+		c.cacheProp(name, value)
+	}
+	return props, nil
+}
+
+// parseProperties parses the output of getprop, which looks like:
+//
+//	[name]: [value]
+//
+// Values may contain newlines.
+func parseProperties(s string) map[string]string {
+	props := map[string]string{}
+	var name, value string
+	for _, line := range strings.Split(s, "\n") {
+		if rest, ok := strings.CutPrefix(line, "["); ok {
+			if n, v, ok := strings.Cut(rest, "]: ["); ok {
+				if name != "" {
+					props[name] = strings.TrimSuffix(value, "]")
+				}
+				name, value = n, v
+				continue
+			}
+		}
+		if name != "" {
+			value += "\n" + line
+		}
+	}
+	if name != "" {
+		props[name] = strings.TrimSuffix(strings.TrimRight(value, "\n"), "]")
+	}
+	return props
+}
+
+// GetProp returns the value of a system property, or an empty string if it
+// isn't set. Read-only (ro.*) properties are cached.
+func (c *FS) GetProp(name string) (string, error) {
+	c.propMu.Lock()
+	value, ok := c.prop[name]
+	c.propMu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	buf, err := c.shell(context.Background(), "getprop "+shellQuote(name), nil)
+	if err != nil {
+		return "", fmt.Errorf("getprop %s: %w", name, err)
+	}
+	value = strings.TrimSuffix(string(buf), "\n")
+
+	c.propMu.Lock()
+	defer c.propMu.Unlock()
+
+	c.cacheProp(name, value)
+	return value, nil
+}
+
+// cacheProp caches a property if it is read-only and set. propMu must be held.
+func (c *FS) cacheProp(name, value string) {
+	if strings.HasPrefix(name, "ro.") && value != "" {
+		if c.prop == nil {
+			c.prop = make(map[string]string)
+		}
+		c.prop[name] = value
+	}
+}
+
+// WatchProp blocks until the value of a system property changes, then
+// returns the new value. The property is polled on the device.
+func (c *FS) WatchProp(ctx context.Context, name string) (string, error) {
+	p := shellQuote(name)
+	buf, err := c.shell(ctx, "v=$(getprop "+p+"); while [ \"$(getprop "+p+")\" = \"$v\" ]; do sleep 0.2; done; getprop "+p, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("watch %s: %w", name, err)
+	}
+	return strings.TrimSuffix(string(buf), "\n"), nil
+}
+
+// SDKVersion returns the API level of the device (ro.build.version.sdk).
+func (c *FS) SDKVersion() (int, error) {
+	v, err := c.GetProp("ro.build.version.sdk")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sdk version %q", v)
+	}
+	return n, nil
+}
+
+// ABIs returns the supported ABIs of the device in order of preference (e.g.,
+// arm64-v8a, armeabi-v7a).
+func (c *FS) ABIs() ([]string, error) {
+	v, err := c.GetProp("ro.product.cpu.abilist")
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		// before Android 5.0
+		if v, err = c.GetProp("ro.product.cpu.abi"); err != nil {
+			return nil, err
+		}
+		if v2, err := c.GetProp("ro.product.cpu.abi2"); err == nil && v2 != "" {
+			v += "," + v2
+		}
+	}
+	if v == "" {
+		return nil, nil
+	}
+	return strings.Split(v, ","), nil
+}
@@ -0,0 +1,56 @@
+package adbfs
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseProperties(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		out   string
+		props map[string]string
+	}{
+		{
+			name: "Simple",
+			out:  "[ro.build.version.sdk]: [34]\n[ro.product.model]: [Pixel 8]\n[empty]: []\n",
+			props: map[string]string{
+				"ro.build.version.sdk": "34",
+				"ro.product.model":     "Pixel 8",
+				"empty":                "",
+			},
+		},
+		{
+			name: "MultiLine",
+			out:  "[a]: [one\ntwo\n\nthree]\n[b]: [x]\n[c]: [last\nline]\n",
+			props: map[string]string{
+				"a": "one\ntwo\n\nthree",
+				"b": "x",
+				"c": "last\nline",
+			},
+		},
+		{
+			name: "Brackets",
+			out:  "[a]: [[x]: [y]]\n",
+			props: map[string]string{
+				"a": "[x]: [y]",
+			},
+		},
+		{
+			name:  "Junk",
+			out:   "junk\n\n",
+			props: map[string]string{},
+		},
+		{
+			name:  "Empty",
+			out:   "",
+			props: map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if props := parseProperties(tc.out); !maps.Equal(props, tc.props) {
+				t.Errorf("expected %q, got %q", tc.props, props)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package adbfs
+
+import (
+	"io"
+	"slices"
+	"sync"
+)
+
+// readWindow is the amount of data kept by a ReaderAt so reads which arrive
+// slightly out of order (e.g., concurrent read-ahead requests) don't require
+// the file to be re-opened.
+const readWindow = 1024 * 1024
+
+// ReaderAt adapts a file opened by Open to io.ReaderAt for servers which read
+// at arbitrary offsets. Reads are streamed from the device sequentially, with
+// the most recently read data kept in a window. It is safe for concurrent use.
+type ReaderAt struct {
+	mu  sync.Mutex
+	f   io.ReadSeekCloser
+	off int64  // of the end of buf in f
+	buf []byte // the last readWindow bytes read
+	eof bool
+}
+
+var _ io.ReaderAt = (*ReaderAt)(nil)
+
+// NewReaderAt returns a ReaderAt for f, which must have been opened by Open.
+func NewReaderAt(f io.ReadSeekCloser) *ReaderAt {
+	return &ReaderAt{f: f}
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// re-open or skip forwards if it's outside the window
+	start := r.off - int64(len(r.buf))
+	if off < start || off > r.off+readWindow {
+		if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.off, r.buf, r.eof = off, r.buf[:0], false
+		start = off
+	}
+
+	// read until we have enough
+	for !r.eof && r.off < off+int64(len(p)) {
+		r.buf = slices.Grow(r.buf, 64*1024)
+		n, err := r.f.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		r.off += int64(n)
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	// discard anything before the window
+	if n := min(r.off-readWindow, off) - start; n > 0 {
+		r.buf = append(r.buf[:0], r.buf[n:]...)
+		start += n
+	}
+
+	if off >= r.off {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf[off-start:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes the underlying file.
+func (r *ReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.f.Close()
+}
@@ -0,0 +1,202 @@
+package adbfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Root restarts adbd as root, then waits for the device to come back and
+// reconnects. It does nothing if adbd is already running as root. This is only
+// possible on userdebug and eng builds. Since the device may not come back, ctx
+// should have a deadline.
+func (c *FS) Root(ctx context.Context) error {
+	return c.restart(ctx, "root:", "already running as root")
+}
+
+// Unroot restarts adbd as the shell user, then waits for the device to come
+// back and reconnects. It does nothing if adbd is not running as root. Like
+// Root, ctx should have a deadline.
+func (c *FS) Unroot(ctx context.Context) error {
+	return c.restart(ctx, "unroot:", "not running as root")
+}
+
+// Remount remounts the system partitions (e.g., /system and /vendor)
+// read-write, returning the output. Root is usually required. On newer
+// devices, this may disable verity, in which case the device must be rebooted
+// (the output will say so) for the partitions to become writable.
+func (c *FS) Remount(ctx context.Context) (string, error) {
+	defer c.invalidate(".")
+
+	if c.hasFeature("remount_shell") {
+		buf, err := c.shell(ctx, "remount 2>&1", nil)
+		if err != nil {
+			if _, ok := err.(*ExitError); ok {
+				err = fmt.Errorf("remount: %s", shellLastLine(buf))
+			}
+			return string(buf), err
+		}
+		return string(buf), nil
+	}
+
+	buf, err := c.deviceService(ctx, "remount:")
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(string(buf), "remount succeeded") {
+		return string(buf), fmt.Errorf("remount: %s", shellLastLine(buf))
+	}
+	return string(buf), nil
+}
+
+// DisableVerity disables dm-verity on the system partitions, returning the
+// output. The device must be rebooted for it to take effect.
+func (c *FS) DisableVerity(ctx context.Context) (string, error) {
+	buf, err := c.deviceService(ctx, "disable-verity:")
+	return string(buf), err
+}
+
+// deviceService runs a device service which writes its output then closes the
+// connection.
+func (c *FS) deviceService(ctx context.Context, svc string) ([]byte, error) {
+	conn, err := adbConnectDevice(c.addr, c.serial, svc)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("service %q: %w", svc, err)
+	}
+	return buf, nil
+}
+
+// restart runs a service which restarts adbd, waits for the device to come
+// back, then resets the connection pool. If the output contains noop, adbd
+// isn't being restarted. It waits indefinitely if the device doesn't come
+// back.
+func (c *FS) restart(ctx context.Context, svc, noop string) error {
+	// the new adbd connection will get a new transport id, so waiting for the
+	// old one to disconnect can't block if it has already reconnected
+	id, idErr := c.transportID(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	buf, err := c.deviceService(ctx, svc)
+	if err != nil {
+		return err
+	}
+	msg := shellLastLine(buf)
+	if strings.Contains(msg, noop) {
+		return nil
+	}
+	if !strings.HasPrefix(msg, "restarting") {
+		return fmt.Errorf("%s %s", strings.TrimSuffix(svc, ":"), msg)
+	}
+
+	// older adb servers don't support transport ids or waiting for a
+	// disconnect, but adbd restarts quickly
+	if idErr != nil || c.waitFor(ctx, "host-transport-id:"+strconv.FormatUint(id, 10)+":wait-for-any-disconnect") != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := c.waitFor(ctx, "host-serial:"+c.serial+":wait-for-any-device"); err != nil {
+		return err
+	}
+	return c.reset()
+}
+
+// transportID gets the id of the adb server's current transport for the
+// device.
+func (c *FS) transportID(ctx context.Context) (uint64, error) {
+	svc := "host:tport:serial:" + c.serial
+	conn, err := adbConnect(c.addr, svc)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	var buf [8]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("service %q: read transport id: %w", svc, err)
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// waitFor runs a wait-for service, which responds once the device is in the
+// requested state.
+func (c *FS) waitFor(ctx context.Context, svc string) error {
+	conn, err := adbConnect(c.addr, svc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	if err := adbRecvOkay(conn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("service %q: %w", svc, err)
+	}
+	return nil
+}
+
+// reset reloads the device features, clears cached properties, and starts a
+// new generation of the connection pool, which is required after adbd
+// restarts. Free connections are closed, and ones currently in use are closed
+// when they are returned. Until then, operations using them fail with
+// ErrReset.
+func (c *FS) reset() error {
+	buf, err := adbConnectSingle(c.addr, "host-serial:"+c.serial+":features")
+	if err != nil {
+		return fmt.Errorf("get device features: %w", err)
+	}
+
+	c.featMu.Lock()
+	c.feat = strings.Split(string(buf), ",")
+	c.featMu.Unlock()
+
+	c.propMu.Lock()
+	c.prop = nil
+	c.propMu.Unlock()
+
+	c.connMu.Lock()
+	c.connGen.Add(1)
+	for conn, free := range c.conn {
+		if free {
+			conn.Close()
+		}
+		delete(c.conn, conn)
+	}
+	c.connMu.Unlock()
+
+	c.invalidate(".")
+	return nil
+}
@@ -0,0 +1,50 @@
+package adbfs
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	c, _, dir := newTestFS(t, "")
+
+	free, err := c.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := c.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.putConn(free)
+
+	if err := c.reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if _, err := free.(*poolConn).Conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected free conn to be closed, got %v", err)
+	}
+
+	// the conn in use still works until adbd closes it
+	if _, err := fsStat(used, dir, c.fsFeat()); err != nil {
+		t.Errorf("expected conn in use to still work, got %v", err)
+	}
+	used.(*poolConn).Conn.Close()
+	if _, err := fsStat(used, dir, c.fsFeat()); !errors.Is(err, ErrReset) {
+		t.Errorf("expected ErrReset, got %v", err)
+	}
+	c.putConn(used)
+
+	conn, err := c.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.putConn(conn)
+	if conn == used || conn == free {
+		t.Errorf("expected a new conn after reset")
+	}
+	if _, err := c.Stat(dir); err != nil {
+		t.Errorf("stat: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package adbfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Screenshot captures the screen using screencap and decodes it.
+func (c *FS) Screenshot(ctx context.Context) (image.Image, error) {
+	buf, err := c.ScreenshotPNG(ctx)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("screenshot: %w", err)
+	}
+	return img, nil
+}
+
+// ScreenshotPNG is like Screenshot, but returns the PNG.
+func (c *FS) ScreenshotPNG(ctx context.Context) ([]byte, error) {
+	buf, err := c.exec(ctx, "screencap -p", nil)
+	if err != nil {
+		return nil, fmt.Errorf("screenshot: %w", err)
+	}
+	if !bytes.HasPrefix(buf, pngSignature) {
+		msg := shellLastLine(buf)
+		if msg == "" {
+			msg = "no output"
+		}
+		return nil, fmt.Errorf("screenshot: %s", msg)
+	}
+	return buf, nil
+}
+
+// ScreenRecordOptions contains options for ScreenRecord.
+type ScreenRecordOptions struct {
+	Width, Height int           // video size, if not zero (--size)
+	BitRate       int           // bits per second, if not zero (--bit-rate)
+	TimeLimit     time.Duration // maximum duration, if not zero (--time-limit)
+	Display       string        // display ID, if not empty (--display-id)
+}
+
+func (o *ScreenRecordOptions) args() string {
+	var args []string
+	if o != nil {
+		if o.Width != 0 && o.Height != 0 {
+			args = append(args, "--size", strconv.Itoa(o.Width)+"x"+strconv.Itoa(o.Height))
+		}
+		if o.BitRate != 0 {
+			args = append(args, "--bit-rate", strconv.Itoa(o.BitRate))
+		}
+		if o.TimeLimit != 0 {
+			args = append(args, "--time-limit", strconv.Itoa(int((o.TimeLimit+time.Second-1)/time.Second)))
+		}
+		if o.Display != "" {
+			args = append(args, "--display-id", o.Display)
+		}
+	}
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(" " + shellQuote(arg))
+	}
+	return b.String()
+}
+
+// ScreenRecord records the screen using screenrecord, writing the raw H.264
+// stream to w until the time limit (three minutes by default) is reached or
+// ctx is cancelled, in which case ctx.Err() is returned. The stream written so
+// far remains valid if it is stopped early. This requires Android 5.0 or
+// later.
+func (c *FS) ScreenRecord(ctx context.Context, w io.Writer, opts *ScreenRecordOptions) error {
+	cmd := "screenrecord --output-format=h264" + opts.args() + " -"
+
+	conn, err := adbConnectDeviceContext(ctx, c.addr, c.serial, "exec:"+cmd)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	// the stream starts with an Annex B start code, otherwise it's an error
+	// message
+	br := bufio.NewReader(conn)
+	if start, err := br.Peek(4); err != nil || !bytes.Equal(start, []byte{0, 0, 0, 1}) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		buf, _ := io.ReadAll(io.LimitReader(br, 4096))
+		if msg := shellLastLine(buf); msg != "" {
+			return fmt.Errorf("screenrecord: %s", msg)
+		}
+		if err == nil || err == io.EOF {
+			return errors.New("screenrecord: no output")
+		}
+		return fmt.Errorf("screenrecord: %w", err)
+	}
+	if _, err := br.WriteTo(w); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("screenrecord: %w", err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package adbfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// shellSession runs commands one at a time in a persistent shell to avoid the
+// overhead of opening a connection for each one. The connection is opened
+// when first needed, and re-opened if a command fails to complete.
+type shellSession struct {
+	c    *FS
+	mu   sync.Mutex
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// run runs cmd, returning the output (including stderr). Commands do not have
+// access to stdin.
+func (s *shellSession) run(ctx context.Context, cmd string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := adbConnectDeviceContext(ctx, s.c.addr, s.c.serial, "exec:sh")
+		if err != nil {
+			return nil, err
+		}
+		s.conn, s.br = conn, bufio.NewReader(conn)
+	}
+	buf, err := s.exchange(ctx, cmd)
+	if err != nil {
+		s.conn.Close()
+		s.conn, s.br = nil, nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("shell %q: %w", cmd, err)
+	}
+
+	return shellExitResult(cmd, buf)
+}
+
+func (s *shellSession) exchange(ctx context.Context, cmd string) ([]byte, error) {
+	conn := s.conn
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	if _, err := conn.Write([]byte(shellExitCommand(cmd, false) + "\n")); err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for {
+		line, err := s.br.ReadBytes('\n')
+		buf = append(buf, line...)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(line, []byte(shellExitMarker)) {
+			return buf, nil
+		}
+	}
+}
+
+// close closes the session.
+func (s *shellSession) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.br = nil, nil
+	return err
+}
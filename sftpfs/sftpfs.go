@@ -0,0 +1,479 @@
+// Package sftpfs implements SFTP request handlers backed by the filesystem of
+// an ADB device.
+package sftpfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	adbfs "github.com/pgaskin/go-adbfs"
+	"github.com/pkg/sftp"
+)
+
+// Options contains options for Handlers and NewServer.
+type Options struct {
+	// ReadOnly rejects all requests which would modify the filesystem.
+	ReadOnly bool
+}
+
+// Handlers returns SFTP request handlers for fsys.
+//
+// Reads are streamed from the device sequentially. Files opened for writing
+// are buffered in a local temporary file, and written to the device when
+// closed.
+func Handlers(fsys *adbfs.FS, opts *Options) sftp.Handlers {
+	h := &handler{fs: fsys}
+	if opts != nil {
+		h.ro = opts.ReadOnly
+	}
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// NewServer creates a new SFTP server for fsys on rwc, which is usually the
+// stdin/stdout of a SSH subsystem.
+func NewServer(rwc io.ReadWriteCloser, fsys *adbfs.FS, opts *Options) *sftp.RequestServer {
+	return sftp.NewRequestServer(rwc, Handlers(fsys, opts))
+}
+
+type handler struct {
+	fs *adbfs.FS
+	ro bool
+}
+
+var (
+	_ sftp.FileReader           = (*handler)(nil)
+	_ sftp.OpenFileWriter       = (*handler)(nil)
+	_ sftp.PosixRenameFileCmder = (*handler)(nil)
+	_ sftp.LstatFileLister      = (*handler)(nil)
+	_ sftp.ReadlinkFileLister   = (*handler)(nil)
+	_ sftp.StatVFSFileCmder     = (*handler)(nil)
+)
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name, err := resolve("open", r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if fi, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, convertError(err)
+	} else if fi.IsDir() {
+		f.Close()
+		return nil, convertError(&fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  adbfs.ErrIsDirectory,
+		})
+	}
+	return &reader{r: adbfs.NewReaderAt(f.(io.ReadSeekCloser))}, nil
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.OpenFile(r)
+}
+
+func (h *handler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	name, err := resolve("open", r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if h.ro {
+		return nil, readOnly("open", name)
+	}
+	flags := r.Pflags()
+
+	mode := fs.FileMode(0644)
+	fi, err := h.fs.Stat(name)
+	switch {
+	case err == nil:
+		if flags.Creat && flags.Excl {
+			return nil, convertError(&fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  fs.ErrExist,
+			})
+		}
+		if fi.IsDir() {
+			return nil, convertError(&fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  adbfs.ErrIsDirectory,
+			})
+		}
+		mode = fi.Mode().Perm()
+	case errors.Is(err, fs.ErrNotExist) && flags.Creat:
+		if r.AttrFlags().Permissions {
+			mode = r.Attributes().FileMode().Perm()
+		}
+	default:
+		return nil, convertError(err)
+	}
+
+	tmp, err := os.CreateTemp("", "adbfs-sftp-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmp.Name()) // we only need the fd
+
+	w := &writer{fs: h.fs, name: name, mode: mode, tmp: tmp}
+	if fi == nil {
+		// so it exists before it's closed
+		if err := h.fs.Send(name, tmp, mode, time.Time{}); err != nil {
+			tmp.Close()
+			return nil, convertError(err)
+		}
+	} else if !flags.Trunc {
+		f, err := h.fs.Open(name)
+		if err == nil {
+			_, err = io.Copy(tmp, f)
+			f.Close()
+		}
+		if err != nil {
+			tmp.Close()
+			return nil, convertError(err)
+		}
+	} else {
+		w.dirty = true
+	}
+	return w, nil
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	name, err := resolve(strings.ToLower(r.Method), r.Filepath)
+	if err != nil && r.Method != "Symlink" {
+		return err
+	}
+	if h.ro {
+		return readOnly(strings.ToLower(r.Method), name)
+	}
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(name, r)
+	case "Rename":
+		target, err := resolve("rename", r.Target)
+		if err != nil {
+			return err
+		}
+		if _, err := h.fs.Stat(target); err == nil {
+			// SFTP v3 renames don't replace existing files
+			return convertError(&os.LinkError{
+				Op:  "rename",
+				Old: name,
+				New: target,
+				Err: fs.ErrExist,
+			})
+		}
+		return convertError(h.fs.Rename(name, target))
+	case "Rmdir":
+		if fi, err := h.fs.Stat(name); err != nil {
+			return convertError(err)
+		} else if !fi.IsDir() {
+			return convertError(&fs.PathError{
+				Op:   "rmdir",
+				Path: name,
+				Err:  adbfs.ErrNotDirectory,
+			})
+		}
+		return convertError(h.fs.Remove(name))
+	case "Remove":
+		if fi, err := h.fs.Stat(name); err != nil {
+			return convertError(err)
+		} else if fi.IsDir() {
+			return convertError(&fs.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  adbfs.ErrIsDirectory,
+			})
+		}
+		return convertError(h.fs.Remove(name))
+	case "Mkdir":
+		return convertError(h.fs.Mkdir(name, 0755))
+	case "Symlink":
+		// Filepath is the target, which isn't resolved
+		target, err := resolve("symlink", r.Target)
+		if err != nil {
+			return err
+		}
+		return convertError(h.fs.Symlink(r.Filepath, target))
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) PosixRename(r *sftp.Request) error {
+	name, err := resolve("rename", r.Filepath)
+	if err != nil {
+		return err
+	}
+	target, err := resolve("rename", r.Target)
+	if err != nil {
+		return err
+	}
+	if h.ro {
+		return readOnly("rename", name)
+	}
+	return convertError(h.fs.Rename(name, target))
+}
+
+func (h *handler) setstat(name string, r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.UidGid {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if flags.Size {
+		if err := h.fs.Truncate(name, int64(attrs.Size)); err != nil {
+			return convertError(err)
+		}
+	}
+	if flags.Permissions {
+		if err := h.fs.Chmod(name, attrs.FileMode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return convertError(err)
+		}
+	}
+	if flags.Acmodtime {
+		if err := h.fs.Chtimes(name, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return convertError(err)
+		}
+	}
+	return nil
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name, err := resolve(strings.ToLower(r.Method), r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		de, err := h.fs.ReadDir(name)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		ls := make(listerAt, 0, len(de))
+		for _, d := range de {
+			fi, err := d.Info()
+			if err != nil {
+				return nil, convertError(err)
+			}
+			ls = append(ls, fileInfo{fi})
+		}
+		return ls, nil
+	case "Stat":
+		return h.Lstat(r)
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	name, err := resolve("stat", r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := h.fs.Stat(name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return listerAt{fileInfo{fi}}, nil
+}
+
+func (h *handler) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+	name, err := resolve("statvfs", r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	st, err := h.fs.StatFS(name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	bs := max(st.BlockSize, 1)
+	vfs := &sftp.StatVFS{
+		Bsize:   uint64(bs),
+		Frsize:  uint64(bs),
+		Blocks:  uint64(st.Total / bs),
+		Bfree:   uint64(st.Free / bs),
+		Bavail:  uint64(st.Available / bs),
+		Files:   uint64(st.Files),
+		Ffree:   uint64(st.FilesFree),
+		Favail:  uint64(st.FilesFree),
+		Namemax: uint64(st.NameMax),
+	}
+	if h.ro || st.ReadOnly() {
+		vfs.Flag |= 0x1 // ST_RDONLY
+	}
+	return vfs, nil
+}
+
+func (h *handler) Readlink(p string) (string, error) {
+	name, err := resolve("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	target, err := h.fs.Readlink(name)
+	return target, convertError(err)
+}
+
+type listerAt []fs.FileInfo
+
+func (l listerAt) ListAt(ls []fs.FileInfo, off int64) (int, error) {
+	if off >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[off:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileInfo adds the owner to a fs.FileInfo.
+type fileInfo struct {
+	fs.FileInfo
+}
+
+var _ sftp.FileInfoUidGid = fileInfo{}
+
+func (fi fileInfo) Uid() uint32 {
+	return fi.Sys().(*adbfs.FileStat).Uid
+}
+
+func (fi fileInfo) Gid() uint32 {
+	return fi.Sys().(*adbfs.FileStat).Gid
+}
+
+// reader is a file opened for reading.
+type reader struct {
+	r *adbfs.ReaderAt
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = convertError(err)
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+// writer is a file opened for writing, buffered in a temporary file.
+type writer struct {
+	fs   *adbfs.FS
+	name string
+	mode fs.FileMode
+
+	mu     sync.Mutex
+	tmp    *os.File
+	dirty  bool
+	failed bool
+}
+
+var _ sftp.TransferError = (*writer)(nil)
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.tmp.ReadAt(p, off)
+}
+
+func (w *writer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.tmp.WriteAt(p, off)
+	if n != 0 {
+		w.dirty = true
+	}
+	return n, err
+}
+
+// TransferError prevents a partially transferred file from being written to
+// the device.
+func (w *writer) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.failed = true
+}
+
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	defer w.tmp.Close()
+	if !w.dirty || w.failed {
+		return nil
+	}
+	return convertError(w.fs.Send(w.name, io.NewSectionReader(w.tmp, 0, 1<<63-1), w.mode, time.Time{}))
+}
+
+// resolve converts a SFTP path into a fs path.
+func resolve(op, name string) (string, error) {
+	if name = strings.TrimPrefix(path.Clean("/"+name), "/"); name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", convertError(&fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  fs.ErrInvalid,
+		})
+	}
+	return name, nil
+}
+
+func readOnly(op, name string) error {
+	return convertError(&fs.PathError{
+		Op:   op,
+		Path: name,
+		Err:  fs.ErrPermission,
+	})
+}
+
+// statusError attaches a SFTP status code to an error while preserving the
+// message.
+type statusError struct {
+	err  error
+	code error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() []error {
+	return []error{e.err, e.code}
+}
+
+// convertError converts errors to ones with the correct SFTP status code.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var code error
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		code = sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, fs.ErrPermission):
+		code = sftp.ErrSSHFxPermissionDenied
+	default:
+		code = sftp.ErrSSHFxFailure
+	}
+	return &statusError{err, code}
+}
@@ -0,0 +1,184 @@
+package adbfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExitError is returned when a command run on the device exits with a
+// non-zero status.
+type ExitError struct {
+	Command  string
+	ExitCode int
+	Stderr   []byte // if the device doesn't support shell_v2, this also contains stdout
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("command %q exited with status %d", e.Command, e.ExitCode)
+	if s := shellLastLine(e.Stderr); s != "" {
+		msg += ": " + s
+	}
+	return msg
+}
+
+// shell runs cmd using the shell, returning the stdout. If stdin is not nil, it
+// is copied to the command's stdin.
+func (c *FS) shell(ctx context.Context, cmd string, stdin io.Reader) ([]byte, error) {
+	if !c.hasFeature(shellFeature_shell_v2) {
+		return c.shellV1(ctx, cmd, stdin)
+	}
+
+	conn, err := adbConnectDevice(c.addr, c.serial, "shell,v2,raw:"+cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	go func() {
+		if stdin != nil {
+			buf := make([]byte, shellDataMax)
+			for {
+				n, err := stdin.Read(buf)
+				if n != 0 {
+					if shellSend(conn, shellID_Stdin, buf[:n]) != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		shellSend(conn, shellID_CloseStdin, nil)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	for {
+		id, data, err := shellRecv(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("shell %q: %w", cmd, err)
+		}
+		switch id {
+		case shellID_Stdout:
+			stdout.Write(data)
+		case shellID_Stderr:
+			stderr.Write(data)
+		case shellID_Exit:
+			if len(data) != 1 {
+				return nil, fmt.Errorf("shell %q: invalid exit packet", cmd)
+			}
+			if data[0] != 0 {
+				return stdout.Bytes(), &ExitError{
+					Command:  cmd,
+					ExitCode: int(data[0]),
+					Stderr:   stderr.Bytes(),
+				}
+			}
+			return stdout.Bytes(), nil
+		}
+	}
+}
+
+// shellV1 is like shell, but uses the exec service, which doesn't separate
+// stdout and stderr, and doesn't return the exit code.
+func (c *FS) shellV1(ctx context.Context, cmd string, stdin io.Reader) ([]byte, error) {
+	conn, err := adbConnectDevice(c.addr, c.serial, "exec:"+shellExitCommand(cmd, true))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() {
+		conn.Close()
+	})()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("shell %q: %w", cmd, err)
+	}
+	return shellExitResult(cmd, buf)
+}
+
+// shellExitMarker is written by shellExitCommand before the exit code.
+const shellExitMarker = "\x00adbfs-exit:"
+
+// shellExitCommand wraps cmd to run in a subshell (so exit works) with stderr
+// redirected to stdout, then write shellExitMarker and the exit code on a line
+// after the output. If !stdin, stdin is redirected from /dev/null.
+func shellExitCommand(cmd string, stdin bool) string {
+	s := "( " + cmd + "\n)"
+	if !stdin {
+		s += " </dev/null"
+	}
+	return s + " 2>&1; printf '\\000" + shellExitMarker[1:] + "%d\\n' $?"
+}
+
+// shellExitResult parses the output of a command wrapped by shellExitCommand.
+func shellExitResult(cmd string, buf []byte) ([]byte, error) {
+	i := bytes.LastIndex(buf, []byte(shellExitMarker))
+	if i == -1 {
+		return nil, fmt.Errorf("shell %q: missing exit code", cmd)
+	}
+	code, err := strconv.Atoi(strings.TrimSuffix(string(buf[i+len(shellExitMarker):]), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("shell %q: invalid exit code: %w", cmd, err)
+	}
+	if buf = buf[:i]; code != 0 {
+		return buf, &ExitError{
+			Command:  cmd,
+			ExitCode: code,
+			Stderr:   buf,
+		}
+	}
+	return buf, nil
+}
+
+// shellFileError attempts to convert an ExitError from a command operating on
+// a file into an Error with the errno parsed from the last line of stderr.
+func shellFileError(err error) error {
+	if xe, ok := err.(*ExitError); ok {
+		if msg := shellLastLine(xe.Stderr); msg != "" {
+			if errno := errnoParse(msg); errno != 0 {
+				return &Error{
+					Msg:   msg,
+					Errno: errno,
+				}
+			}
+		}
+	}
+	return err
+}
+
+// shellQuote quotes s for use as a single argument in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellLastLine(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if i := strings.LastIndexByte(s, '\n'); i != -1 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,331 @@
+package adbfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ShellFS accesses files on the device using shell commands run through a
+// wrapper like run-as or su, for files which aren't accessible to the sync
+// service (which runs as the shell user).
+//
+// Like FS, Stat does not follow symlinks. Files are read into memory when
+// opened.
+type ShellFS struct {
+	c      *FS
+	prefix string
+}
+
+var (
+	_ fs.FS         = (*ShellFS)(nil)
+	_ fs.StatFS     = (*ShellFS)(nil)
+	_ fs.ReadDirFS  = (*ShellFS)(nil)
+	_ fs.ReadFileFS = (*ShellFS)(nil)
+)
+
+// ShellFS returns a ShellFS which runs commands by appending them, quoted as a
+// single argument, to prefix (e.g., "su 0 sh -c").
+func (c *FS) ShellFS(prefix string) *ShellFS {
+	return &ShellFS{c: c, prefix: prefix}
+}
+
+// RunAs returns a ShellFS which runs commands as the specified package using
+// run-as. The package must be debuggable.
+func (c *FS) RunAs(pkg string) *ShellFS {
+	return c.ShellFS("run-as " + shellQuote(pkg) + " sh -c")
+}
+
+// Su returns a ShellFS which runs commands as root using su -c.
+func (c *FS) Su() *ShellFS {
+	return c.ShellFS("su -c")
+}
+
+// shellStatFormat is the stat -c format for a sync_stat_v2.
+const shellStatFormat = "%f %h %u %g %s %X %Y %Z %d %i"
+
+func (s *ShellFS) shell(cmd string) ([]byte, error) {
+	return s.c.shell(context.Background(), s.prefix+" "+shellQuote(cmd), nil)
+}
+
+func (s *ShellFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	buf, err := s.shell("stat -c '" + shellStatFormat + "' -- " + shellQuote("/"+name))
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	st, _, ok := parseShellStat(strings.TrimSuffix(string(buf), "\n"), false)
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  errors.New("invalid stat output"),
+		}
+	}
+	return &fsFileInfo{name: path.Base(name), st: st}, nil
+}
+
+func (s *ShellFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	buf, err := s.shell(shellReadDir("/" + name))
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return parseShellReadDir(buf), nil
+}
+
+func (s *ShellFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "readfile",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	buf, err := s.shell("cat -- " + shellQuote("/"+name))
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readfile",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return buf, nil
+}
+
+// Open opens the named file, reading the contents (or directory entries) into
+// memory using a single command.
+func (s *ShellFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	buf, err := s.shell("stat -c '" + shellStatFormat + "' -- " + p + " && if [ -d " + p + " ] && [ ! -L " + p + " ]; then " + shellReadDir("/"+name) + "; else cat -- " + p + "; fi")
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	line, rest, _ := bytes.Cut(buf, []byte{'\n'})
+	st, _, ok := parseShellStat(string(line), false)
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  errors.New("invalid stat output"),
+		}
+	}
+	f := &shellFile{
+		name: name,
+		fi:   &fsFileInfo{name: path.Base(name), st: st},
+	}
+	if syncMode(st.Mode).IsDir() {
+		f.de = parseShellReadDir(rest)
+	} else {
+		f.r = bytes.NewReader(rest)
+	}
+	return f, nil
+}
+
+// shellReadDir returns a command to stat the entries of dir.
+func shellReadDir(dir string) string {
+	p := shellQuote(dir)
+	return "if [ -e " + p + " ] && [ ! -d " + p + " ]; then echo " + shellQuote(dir+": Not a directory") + " >&2; exit 1; fi; cd -- " + p + " && find . -mindepth 1 -maxdepth 1 -exec stat -c '" + shellStatFormat + " %n' {} +"
+}
+
+// parseShellReadDir parses the output of shellReadDir, returning the entries
+// sorted by name.
+func parseShellReadDir(buf []byte) []fs.DirEntry {
+	var de []fs.DirEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		if st, name, ok := parseShellStat(line, true); ok {
+			if name, ok = strings.CutPrefix(name, "./"); ok && name != "" {
+				de = append(de, &fsDirEntry{name: name, st: st})
+				continue
+			}
+		}
+		// the name contained a newline
+		if len(de) != 0 {
+			d := de[len(de)-1].(*fsDirEntry)
+			d.name += "\n" + line
+		}
+	}
+	slices.SortFunc(de, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return de
+}
+
+// parseShellStat parses a line of stat output in shellStatFormat, optionally
+// followed by a name.
+func parseShellStat(line string, named bool) (*sync_stat_v2, string, bool) {
+	n := 10
+	if named {
+		n++
+	}
+	f := strings.SplitN(line, " ", n)
+	if len(f) != n {
+		return nil, "", false
+	}
+	var (
+		st  sync_stat_v2
+		err error
+		u   uint64
+	)
+	u, err = strconv.ParseUint(f[0], 16, 32)
+	st.Mode = uint32(u)
+	for i, v := range []any{&st.Nlink, &st.Uid, &st.Gid, &st.Size, &st.Atime, &st.Mtime, &st.Ctime, &st.Dev, &st.Ino} {
+		if err != nil {
+			break
+		}
+		switch v := v.(type) {
+		case *uint32:
+			u, err = strconv.ParseUint(f[i+1], 10, 32)
+			*v = uint32(u)
+		case *uint64:
+			*v, err = strconv.ParseUint(f[i+1], 10, 64)
+		case *int64:
+			*v, err = strconv.ParseInt(f[i+1], 10, 64)
+		}
+	}
+	if err != nil {
+		return nil, "", false
+	}
+	if named {
+		return &st, f[10], true
+	}
+	return &st, "", true
+}
+
+// shellFile is a file opened by ShellFS.
+type shellFile struct {
+	name string
+	fi   fs.FileInfo
+	r    *bytes.Reader // nil if a directory
+	de   []fs.DirEntry // remaining entries if a directory
+	err  error         // set once closed
+}
+
+var (
+	_ fs.ReadDirFile = (*shellFile)(nil)
+	_ io.ReaderAt    = (*shellFile)(nil)
+	_ io.Seeker      = (*shellFile)(nil)
+)
+
+func (f *shellFile) Stat() (fs.FileInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.fi, nil
+}
+
+func (f *shellFile) Read(p []byte) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.r.Read(p)
+}
+
+func (f *shellFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func (f *shellFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check("seek"); err != nil {
+		return 0, err
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *shellFile) check(op string) error {
+	if f.err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  f.err,
+		}
+	}
+	if f.r == nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  ErrIsDirectory,
+		}
+	}
+	return nil
+}
+
+func (f *shellFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: f.name,
+			Err:  f.err,
+		}
+	}
+	if f.r != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: f.name,
+			Err:  ErrNotDirectory,
+		}
+	}
+	if n <= 0 {
+		de := f.de
+		f.de = nil
+		return de, nil
+	}
+	if len(f.de) == 0 {
+		return nil, io.EOF
+	}
+	de := f.de[:min(n, len(f.de))]
+	f.de = f.de[len(de):]
+	return de, nil
+}
+
+func (f *shellFile) Close() error {
+	if f.err != nil {
+		return &fs.PathError{
+			Op:   "close",
+			Path: f.name,
+			Err:  f.err,
+		}
+	}
+	f.err = fs.ErrClosed
+	return nil
+}
@@ -0,0 +1,101 @@
+package adbfs
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestParseShellStat(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		named bool
+		st    *sync_stat_v2
+		name  string
+	}{
+		{
+			line: "81a4 1 2000 2000 1234 1700000001 1700000002 1700000003 64768 42",
+			st:   &sync_stat_v2{Mode: 0100644, Nlink: 1, Uid: 2000, Gid: 2000, Size: 1234, Atime: 1700000001, Mtime: 1700000002, Ctime: 1700000003, Dev: 64768, Ino: 42},
+		},
+		{
+			line:  "41ed 2 0 0 4096 1 2 3 4 5 ./a name with spaces",
+			named: true,
+			st:    &sync_stat_v2{Mode: 040755, Nlink: 2, Size: 4096, Atime: 1, Mtime: 2, Ctime: 3, Dev: 4, Ino: 5},
+			name:  "./a name with spaces",
+		},
+		{
+			line: "41ed 2 0 0 4096 1 2 3 4 5 ./extra",
+		},
+		{
+			line:  "41ed 2 0 0 4096 1 2 3 4 5",
+			named: true,
+		},
+		{
+			line: "zzzz 2 0 0 4096 1 2 3 4 5",
+		},
+		{
+			line: "41ed 2 0 0 -1 1 2 3 4 5",
+		},
+		{
+			line: "stat: '/x': No such file or directory",
+		},
+		{
+			line: "",
+		},
+	} {
+		st, name, ok := parseShellStat(tc.line, tc.named)
+		if ok != (tc.st != nil) {
+			t.Errorf("%q: expected ok=%t, got %t", tc.line, tc.st != nil, ok)
+			continue
+		}
+		if ok && (*st != *tc.st || name != tc.name) {
+			t.Errorf("%q: expected %+v %q, got %+v %q", tc.line, *tc.st, tc.name, *st, name)
+		}
+	}
+}
+
+func TestParseShellReadDir(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		out   string
+		names []string
+	}{
+		{"Empty", "", nil},
+		{"Sorted", "" +
+			"81a4 1 0 0 1 0 0 0 0 1 ./b\n" +
+			"41ed 2 0 0 4096 0 0 0 0 2 ./a\n" +
+			"a1ff 1 0 0 1 0 0 0 0 3 ./c d\n",
+			[]string{"a", "b", "c d"}},
+		{"Newline", "" +
+			"81a4 1 0 0 1 0 0 0 0 1 ./a\n" +
+			"b\n" +
+			"81a4 1 0 0 1 0 0 0 0 2 ./c\n",
+			[]string{"a\nb", "c"}},
+		{"MultipleNewlines", "" +
+			"81a4 1 0 0 1 0 0 0 0 1 ./a\n" +
+			"\n" +
+			"b\n" +
+			"81a4 1 0 0 1 0 0 0 0 2 ./c\n" +
+			"\n",
+			[]string{"a\n\nb", "c\n"}},
+		{"LeadingGarbage", "" +
+			"garbage\n" +
+			"81a4 1 0 0 1 0 0 0 0 1 ./a\n",
+			[]string{"a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var names []string
+			for _, d := range parseShellReadDir([]byte(tc.out)) {
+				names = append(names, d.Name())
+			}
+			if !slices.Equal(names, tc.names) {
+				t.Errorf("expected %q, got %q", tc.names, names)
+			}
+		})
+	}
+
+	de := parseShellReadDir([]byte("41ed 2 0 0 4096 0 0 0 0 2 ./a\na1ff 1 0 0 1 0 0 0 0 3 ./b\n"))
+	if len(de) != 2 || !de[0].IsDir() || de[1].Type() != fs.ModeSymlink {
+		t.Errorf("incorrect entry types")
+	}
+}
@@ -0,0 +1,158 @@
+package adbfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mount is an entry in the device's mount table.
+type Mount struct {
+	Device  string   // mount source (e.g., /dev/block/dm-4)
+	Path    string   // absolute mount point on the device
+	Type    string   // filesystem type (e.g., ext4)
+	Options []string // mount options (e.g., ro, nosuid)
+}
+
+// ReadOnly returns true if the filesystem is mounted read-only.
+func (m Mount) ReadOnly() bool {
+	return slices.Contains(m.Options, "ro")
+}
+
+// FSStat contains information about a filesystem.
+type FSStat struct {
+	Mount            // the mount containing the file, if found
+	BlockSize int64  // fundamental block size
+	Total     int64  // size in bytes
+	Free      int64  // free bytes
+	Available int64  // free bytes available to unprivileged users
+	Files     int64  // total inodes
+	FilesFree int64  // free inodes
+	NameMax   int64  // maximum file name length
+	Magic     uint64 // filesystem type magic number
+}
+
+// Mounts returns the mount table of the device, as seen by adbd.
+func (c *FS) Mounts() ([]Mount, error) {
+	buf, err := c.ReadFile("proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	return parseMounts(buf), nil
+}
+
+// parseMounts parses /proc/mounts.
+func parseMounts(buf []byte) []Mount {
+	var mounts []Mount
+	for _, line := range bytes.Split(buf, []byte{'\n'}) {
+		f := strings.Fields(string(line))
+		if len(f) < 4 {
+			continue
+		}
+		mounts = append(mounts, Mount{
+			Device:  unescapeMount(f[0]),
+			Path:    unescapeMount(f[1]),
+			Type:    unescapeMount(f[2]),
+			Options: strings.Split(unescapeMount(f[3]), ","),
+		})
+	}
+	return mounts
+}
+
+// unescapeMount decodes the octal escapes used for whitespace and backslashes
+// in /proc/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// StatFS returns information about the filesystem containing the named file,
+// using stat -f on the device. The mount is found by resolving symlinks in the
+// path and finding the longest matching mount point in the mount table.
+func (c *FS) StatFS(name string) (*FSStat, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	buf, err := c.shell(context.Background(), "stat -f -c '%t %S %b %f %a %c %d %l' -- "+p+" && readlink -f -- "+p, nil)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+
+	var (
+		st   FSStat
+		real string
+	)
+	if line, rest, ok := strings.Cut(string(buf), "\n"); ok {
+		real = strings.TrimSuffix(rest, "\n")
+		f := strings.Fields(line)
+		if len(f) == 8 {
+			st.Magic, err = strconv.ParseUint(f[0], 16, 64)
+			for i, v := range []*int64{&st.BlockSize, &st.Total, &st.Free, &st.Available, &st.Files, &st.FilesFree, &st.NameMax} {
+				if err == nil {
+					*v, err = strconv.ParseInt(f[i+1], 10, 64)
+				}
+			}
+		} else {
+			err = fmt.Errorf("expected 8 fields, got %d", len(f))
+		}
+	} else {
+		err = errors.New("missing readlink output")
+	}
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  fmt.Errorf("parse stat output %q: %w", buf, err),
+		}
+	}
+	st.Total *= st.BlockSize
+	st.Free *= st.BlockSize
+	st.Available *= st.BlockSize
+
+	if mounts, err := c.Mounts(); err == nil {
+		if real == "" {
+			real = "/" + name
+		}
+		for _, m := range mounts {
+			// later mounts hide earlier ones at the same path
+			if pathContains(m.Path, real) && len(m.Path) >= len(st.Mount.Path) {
+				st.Mount = m
+			}
+		}
+	}
+	return &st, nil
+}
+
+// pathContains returns true if the absolute path name is dir or is inside it.
+func pathContains(dir, name string) bool {
+	dir, name = path.Clean(dir), path.Clean(name)
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
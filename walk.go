@@ -0,0 +1,174 @@
+package adbfs
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// WalkOptions contains options for Walk.
+type WalkOptions struct {
+	// Workers is the maximum number of concurrent directory listings. If zero,
+	// 8 is used.
+	Workers int
+}
+
+// Walk walks the file tree rooted at root like fs.WalkDir, but lists
+// directories concurrently over multiple connections, pipelining requests
+// where possible.
+//
+// The order in which entries are visited is undefined, other than a directory
+// always being visited before its contents. The entries of a single directory
+// are visited in lexical order. fn is never called concurrently.
+//
+// Like fs.WalkDir, returning fs.SkipDir for a directory skips its contents,
+// returning fs.SkipDir for a file skips the remaining files in its directory,
+// and returning fs.SkipAll stops the walk without an error. If ctx is
+// cancelled, the walk stops and ctx.Err() is returned.
+func (c *FS) Walk(ctx context.Context, root string, fn fs.WalkDirFunc, opts *WalkOptions) error {
+	workers := 8
+	if opts != nil && opts.Workers > 0 {
+		workers = opts.Workers
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fi, err := c.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fn(root, fs.FileInfoToDirEntry(fi), nil)
+		if err == nil && fi.IsDir() {
+			w := &walker{
+				c:       c,
+				ctx:     ctx,
+				fn:      fn,
+				workers: workers,
+				queue:   []walkDir{{root, fs.FileInfoToDirEntry(fi)}},
+			}
+			w.cond = sync.NewCond(&w.mu)
+
+			var wg sync.WaitGroup
+			for range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.work()
+				}()
+			}
+			wg.Wait()
+
+			err = w.err
+		}
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+type walkDir struct {
+	name string
+	d    fs.DirEntry
+}
+
+type walker struct {
+	c       *FS
+	ctx     context.Context
+	fn      fs.WalkDirFunc
+	workers int
+
+	mu     sync.Mutex // also serializes calls to fn
+	cond   *sync.Cond
+	queue  []walkDir
+	active int
+	err    error
+}
+
+func (w *walker) work() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		for len(w.queue) == 0 && w.active != 0 && w.err == nil {
+			w.cond.Wait()
+		}
+		if w.err != nil || len(w.queue) == 0 {
+			w.cond.Broadcast()
+			return
+		}
+
+		// take the most recently queued directories (this keeps the queue
+		// smaller than going breadth-first), splitting them between the
+		// workers, and pipelining them if there's more than we have workers
+		n := min(max(len(w.queue)/w.workers, 1), syncPipelineMax)
+		batch := slices.Clone(w.queue[len(w.queue)-n:])
+		w.queue = w.queue[:len(w.queue)-n]
+		w.active++
+
+		w.mu.Unlock()
+		var (
+			de   [][]fs.DirEntry
+			errs []error
+		)
+		err := w.ctx.Err()
+		if err == nil {
+			names := make([]string, len(batch))
+			for i, dir := range batch {
+				names[i] = dir.name
+			}
+			de, errs = w.c.ReadDirMany(names)
+		}
+		w.mu.Lock()
+
+		w.active--
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+		} else {
+			for i, dir := range batch {
+				if w.err != nil {
+					break
+				}
+				if err := w.visit(dir, de[i], errs[i]); err != nil {
+					w.err = err
+				}
+			}
+		}
+		w.cond.Broadcast()
+	}
+}
+
+func (w *walker) visit(dir walkDir, de []fs.DirEntry, err error) error {
+	if err != nil {
+		if err := w.fn(dir.name, dir.d, err); err != nil && err != fs.SkipDir {
+			return err
+		}
+		return nil
+	}
+	slices.SortFunc(de, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	for _, d := range de {
+		name := path.Join(dir.name, d.Name())
+		if err := w.fn(name, d, nil); err != nil {
+			if err == fs.SkipDir {
+				if d.IsDir() {
+					continue
+				}
+				break
+			}
+			return err
+		}
+		if d.IsDir() {
+			w.queue = append(w.queue, walkDir{name, d})
+		}
+	}
+	return nil
+}
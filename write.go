@@ -0,0 +1,333 @@
+package adbfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteFile writes data to the named file, creating it if necessary. It is
+// like Send, but with the current time as the modification time.
+func (c *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return c.Send(name, bytes.NewReader(data), perm.Perm(), time.Time{})
+}
+
+// Send writes the contents of r to the named file, replacing it if it exists,
+// and creating missing parent directories. The file is created with the
+// permission bits from mode, and the modification time is set to mtime (or the
+// current time if it is zero).
+//
+// If mode is fs.ModeSymlink, a symlink is created instead, with the contents of
+// r as the target.
+//
+// If an error occurs, a partially written file may be left on the device.
+func (c *FS) Send(name string, r io.Reader, mode fs.FileMode, mtime time.Time) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{
+			Op:   "send",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	if t := mode.Type(); t != 0 && t != fs.ModeSymlink {
+		return &fs.PathError{
+			Op:   "send",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+
+	err = fsSend(conn, name, r, mode, mtime)
+	if err != nil {
+		c.delConn(conn) // the device will close it if it failed anyways
+	} else {
+		c.putConn(conn)
+	}
+	c.invalidate(name)
+	return err
+}
+
+func fsSend(conn net.Conn, name string, r io.Reader, mode fs.FileMode, mtime time.Time) error {
+	id := syncID_SEND_V1
+	if err := syncRequest(conn, id, "/"+name+","+strconv.FormatUint(uint64(syncModeFrom(mode)), 10)); err != nil {
+		return &fs.PathError{
+			Op:   "send",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	// if the device fails, it will send an error and close the connection,
+	// so we still want to try and read the error if the write fails
+	var werr error
+	buf := make([]byte, syncDataMax)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n != 0 {
+			if werr = syncRequestData(conn, buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return &fs.PathError{
+				Op:   "send",
+				Path: name,
+				Err:  err,
+			}
+		}
+	}
+	if werr == nil {
+		werr = syncRequestDone(conn, uint32(mtime.Unix()))
+	}
+
+	if err := syncResponse(conn); err != nil {
+		var derr *Error
+		if werr == nil || errors.As(err, &derr) {
+			werr = err
+		}
+	}
+	if werr != nil {
+		return &fs.PathError{
+			Op:   "send",
+			Path: name,
+			Err:  werr,
+		}
+	}
+	return nil
+}
+
+// Mkdir creates a directory with the specified permission bits.
+func (c *FS) Mkdir(name string, perm fs.FileMode) error {
+	return c.mkdir("mkdir", name, perm, false)
+}
+
+// MkdirAll creates a directory, along with any necessary parents. The
+// permission bits are only used for the last directory.
+func (c *FS) MkdirAll(name string, perm fs.FileMode) error {
+	return c.mkdir("mkdirall", name, perm, true)
+}
+
+func (c *FS) mkdir(op, name string, perm fs.FileMode, all bool) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	cmd := "mkdir "
+	if all {
+		cmd += "-p "
+	}
+	cmd += "-m " + strconv.FormatUint(uint64(perm.Perm()), 8) + " -- " + shellQuote("/"+name)
+	_, err := c.shell(context.Background(), cmd, nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (c *FS) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{
+			Op:   "remove",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	_, err := c.shell(context.Background(), "if [ -d "+p+" ] && [ ! -L "+p+" ]; then rmdir -- "+p+"; else rm -- "+p+"; fi", nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "remove",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes the named file or directory and anything it contains. If
+// it does not exist, nil is returned.
+func (c *FS) RemoveAll(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	_, err := c.shell(context.Background(), "rm -rf -- "+shellQuote("/"+name), nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// Rename renames oldname to newname, replacing newname if it is a file or an
+// empty directory.
+func (c *FS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || oldname == "." || !fs.ValidPath(newname) || newname == "." {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: fs.ErrInvalid,
+		}
+	}
+	o, n := shellQuote("/"+oldname), shellQuote("/"+newname)
+	_, err := c.shell(context.Background(), "if [ -d "+n+" ] && [ ! -L "+n+" ] && [ -e "+o+" ]; then rmdir -- "+n+" || exit; fi; mv -f -- "+o+" "+n, nil)
+	c.invalidate(oldname)
+	c.invalidate(newname)
+	if err != nil {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (c *FS) Symlink(oldname, newname string) error {
+	return c.Send(newname, strings.NewReader(oldname), fs.ModeSymlink|0777, time.Time{})
+}
+
+// Readlink returns the target of the named symbolic link.
+func (c *FS) Readlink(name string) (string, error) {
+	return c.readlink(context.Background(), name)
+}
+
+func (c *FS) readlink(ctx context.Context, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	buf, err := c.shell(ctx, "readlink -- "+p+" || { [ -e "+p+" ] || [ -L "+p+" ] || echo "+shellQuote("/"+name+": No such file or directory")+" >&2; exit 1; }", nil)
+	if err != nil {
+		if xe, ok := err.(*ExitError); ok && len(xe.Stderr) == 0 {
+			err = syncErrno(errno_EINVAL) // readlink doesn't print an error, but this is what readlink(2) returns
+		}
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return strings.TrimSuffix(string(buf), "\n"), nil
+}
+
+// Truncate changes the size of the named file without transferring it.
+func (c *FS) Truncate(name string, size int64) error {
+	if !fs.ValidPath(name) || size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	_, err := c.shell(context.Background(), "if [ -d "+p+" ]; then echo "+shellQuote("/"+name+": Is a directory")+" >&2; exit 1; elif [ ! -e "+p+" ]; then echo "+shellQuote("/"+name+": No such file or directory")+" >&2; exit 1; fi; truncate -s "+strconv.FormatInt(size, 10)+" -- "+p, nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// Chmod changes the permission bits of the named file.
+func (c *FS) Chmod(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "chmod",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	_, err := c.shell(context.Background(), "chmod "+strconv.FormatUint(uint64(syncModeFrom(mode)&07777), 8)+" -- "+shellQuote("/"+name), nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "chmod",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file. If
+// either is zero, it is not changed.
+func (c *FS) Chtimes(name string, atime, mtime time.Time) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "chtimes",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	p := shellQuote("/" + name)
+	cmd := []string{"{ [ -e " + p + " ] || [ -L " + p + " ] || { echo " + shellQuote("/"+name+": No such file or directory") + " >&2; exit 1; }; }"} // touch -c doesn't fail if it doesn't exist
+	for _, t := range []struct {
+		flag string
+		time time.Time
+	}{{"-a", atime}, {"-m", mtime}} {
+		if !t.time.IsZero() {
+			cmd = append(cmd, "touch -c -h "+t.flag+" -d @"+strconv.FormatInt(t.time.Unix(), 10)+"."+fmt.Sprintf("%09d", t.time.Nanosecond())+" -- "+p)
+		}
+	}
+	if len(cmd) == 1 {
+		return nil
+	}
+	_, err := c.shell(context.Background(), strings.Join(cmd, " && "), nil)
+	c.invalidate(name)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "chtimes",
+			Path: name,
+			Err:  shellFileError(err),
+		}
+	}
+	return nil
+}
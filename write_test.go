@@ -0,0 +1,112 @@
+package adbfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testFeatures are the feature sets to test the sync and shell operations with.
+var testFeatures = []struct {
+	name     string
+	features string
+}{
+	{"V2", "shell_v2,cmd,stat_v2,ls_v2"},
+	{"StatV2", "shell_v2,cmd,stat_v2"},
+	{"V1", "cmd"},
+}
+
+func TestWrite(t *testing.T) {
+	for _, tf := range testFeatures {
+		t.Run(tf.name, func(t *testing.T) {
+			c, _, dir := newTestFS(t, tf.features)
+
+			if err := c.WriteFile(dir+"/a", []byte("hello world"), 0640); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := c.Symlink("a", dir+"/link"); err != nil {
+				t.Fatalf("symlink: %v", err)
+			}
+			if err := c.Mkdir(dir+"/sub", 0755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+
+			// readdir
+			de, err := c.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("readdir: %v", err)
+			}
+			var names []string
+			for _, d := range de {
+				names = append(names, d.Name()+":"+d.Type().String())
+			}
+			if exp := []string{"a:----------", "link:L---------", "sub:d---------"}; !slices.Equal(names, exp) {
+				t.Errorf("readdir: expected %q, got %q", exp, names)
+			}
+			if len(de) == 3 {
+				ino := de[0].(*fsDirEntry).Sys().(*FileStat).Ino
+				if tf.features == testFeatures[0].features && ino == 0 {
+					t.Errorf("readdir: expected inode with ls_v2")
+				}
+			}
+
+			// readlink
+			if target, err := c.Readlink(dir + "/link"); err != nil || target != "a" {
+				t.Errorf("readlink: expected %q, got %q %v", "a", target, err)
+			}
+			if _, err := c.Readlink(dir + "/a"); !errors.As(err, new(*Error)) || err.(*fs.PathError).Err.(*Error).Errno != errno_EINVAL {
+				t.Errorf("readlink: expected EINVAL for a regular file, got %v", err)
+			}
+			if _, err := c.Readlink(dir + "/missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("readlink: expected not exist, got %v", err)
+			}
+
+			// chmod
+			if err := c.Chmod(dir+"/a", 0600|fs.ModeSticky); err != nil {
+				t.Errorf("chmod: %v", err)
+			} else if fi, err := os.Stat("/" + dir + "/a"); err != nil || fi.Mode() != 0600|fs.ModeSticky {
+				t.Errorf("chmod: expected mode %s, got %v %v", 0600|fs.ModeSticky, fi.Mode(), err)
+			}
+			if err := c.Chmod(dir+"/missing", 0600); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("chmod: expected not exist, got %v", err)
+			}
+
+			// chtimes
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if err := c.Chtimes(dir+"/a", time.Time{}, mtime); err != nil {
+				t.Errorf("chtimes: %v", err)
+			} else if fi, err := c.Stat(dir + "/a"); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("chtimes: expected mtime %s, got %v %v", mtime, fi.ModTime(), err)
+			}
+			if err := c.Chtimes(dir+"/link", mtime, mtime); err != nil {
+				t.Errorf("chtimes: %v", err)
+			} else if fi, err := os.Lstat("/" + dir + "/link"); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("chtimes: expected symlink mtime %s, got %v %v", mtime, fi.ModTime(), err)
+			}
+			if err := c.Chtimes(dir+"/missing", mtime, mtime); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("chtimes: expected not exist, got %v", err)
+			}
+
+			// truncate
+			if err := c.Truncate(dir+"/a", 5); err != nil {
+				t.Errorf("truncate: %v", err)
+			} else if buf, err := c.ReadFile(dir + "/a"); err != nil || string(buf) != "hello" {
+				t.Errorf("truncate: expected %q, got %q %v", "hello", buf, err)
+			}
+			if err := c.Truncate(dir+"/a", 7); err != nil {
+				t.Errorf("truncate: %v", err)
+			} else if buf, err := c.ReadFile(dir + "/a"); err != nil || string(buf) != "hello\x00\x00" {
+				t.Errorf("truncate: expected %q, got %q %v", "hello\x00\x00", buf, err)
+			}
+			if err := c.Truncate(dir+"/sub", 0); !errors.Is(err, ErrIsDirectory) {
+				t.Errorf("truncate: expected is a directory, got %v", err)
+			}
+			if err := c.Truncate(dir+"/missing", 0); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("truncate: expected not exist, got %v", err)
+			}
+		})
+	}
+}